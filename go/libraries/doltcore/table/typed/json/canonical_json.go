@@ -0,0 +1,81 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// canonicalJSON validates the JSON document given and decodes it into a structure that encodes canonically: object
+// keys sorted, no insignificant whitespace, and normalized numbers. Integers keep their exact digits, however large,
+// while all other numbers are written in the shortest form that round trips through a float64, so that e.g. 1.50 and
+// 15e-1 are both written as 1.5.
+func canonicalJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	return normalizeNumbers(val)
+}
+
+func normalizeNumbers(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, nested := range v {
+			var err error
+			if v[k], err = normalizeNumbers(nested); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i, nested := range v {
+			var err error
+			if v[i], err = normalizeNumbers(nested); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			if v == "-0" {
+				return json.Number("0"), nil
+			}
+			return v, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(b), nil
+	default:
+		return val, nil
+	}
+}
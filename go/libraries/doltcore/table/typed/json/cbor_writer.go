@@ -0,0 +1,47 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+)
+
+// cborArrayStart and cborBreak open and close an indefinite length CBOR array, which lets rows be streamed without
+// knowing their count up front
+const cborArrayStart = "\x9f"
+const cborBreak = "\xff"
+
+// cborEncMode encodes maps with deterministically sorted keys, so the same rows always produce the same bytes
+var cborEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+// NewCBORWriter returns a new writer that encodes rows as a CBOR array of maps, one map per row, for consumers that
+// prefer a more compact encoding than JSON. Column values are converted exactly as the JSON writer converts them, so
+// e.g. datetimes are strings and NULL columns are omitted from their row's map. Options that add fields to the JSON
+// envelope, or that require line delimited output, are not supported.
+func NewCBORWriter(wr io.WriteCloser, outSch schema.Schema, opts ...WriterOption) (*RowWriter, error) {
+	opts = append([]WriterOption{withCBOREncoding(), WithEnvelopeValidation(false)}, opts...)
+	return NewJSONWriterWithHeader(wr, outSch, cborArrayStart, cborBreak, "", opts...)
+}
+
+func withCBOREncoding() WriterOption {
+	return func(j *RowWriter) error {
+		j.marshalRow = cborEncMode.Marshal
+		return nil
+	}
+}
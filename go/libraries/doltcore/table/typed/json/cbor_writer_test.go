@@ -0,0 +1,84 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/row"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+	"github.com/dolthub/dolt/go/libraries/utils/iohelp"
+	"github.com/dolthub/dolt/go/store/types"
+)
+
+func TestCBORWriter(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "created", Tag: 2, Kind: types.TimestampKind, TypeInfo: typeinfo.DatetimeType},
+	)
+	ts := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	expected := []map[string]interface{}{
+		{"id": uint64(1), "name": "one", "created": "2022-03-04 05:06:07"},
+		{"id": uint64(2)},
+	}
+
+	t.Run("sql rows", func(t *testing.T) {
+		var buf bytes.Buffer
+		wr, err := NewCBORWriter(iohelp.NopWrCloser(&buf), sch)
+		require.NoError(t, err)
+		ctx := context.Background()
+		require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(1), "one", ts}))
+		require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(2), nil, nil}))
+		require.NoError(t, wr.Close(ctx))
+
+		var decoded []map[string]interface{}
+		require.NoError(t, cbor.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, expected, decoded)
+	})
+
+	t.Run("noms rows", func(t *testing.T) {
+		var buf bytes.Buffer
+		wr, err := NewCBORWriter(iohelp.NopWrCloser(&buf), sch)
+		require.NoError(t, err)
+		ctx := context.Background()
+		for _, tv := range []row.TaggedValues{
+			{0: types.Int(1), 1: types.String("one"), 2: types.Timestamp(ts)},
+			{0: types.Int(2)},
+		} {
+			r, err := row.New(types.Format_Default, sch, tv)
+			require.NoError(t, err)
+			require.NoError(t, wr.WriteRow(ctx, r))
+		}
+		require.NoError(t, wr.Close(ctx))
+
+		var decoded []map[string]interface{}
+		require.NoError(t, cbor.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, expected, decoded)
+	})
+
+	_, err := NewCBORWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithDocumentChecksum(ChecksumSHA256))
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const checksumKey = "checksum"
+
+// ChecksumAlgorithm names the hash function used to compute a document checksum
+type ChecksumAlgorithm string
+
+const (
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	ChecksumSHA1   ChecksumAlgorithm = "sha1"
+	ChecksumMD5    ChecksumAlgorithm = "md5"
+)
+
+var checksumHashes = map[ChecksumAlgorithm]func() hash.Hash{
+	ChecksumSHA256: sha256.New,
+	ChecksumSHA1:   sha1.New,
+	ChecksumMD5:    md5.New,
+}
+
+func (algo ChecksumAlgorithm) newHash() hash.Hash {
+	return checksumHashes[algo]()
+}
+
+// VerifyDocumentChecksum reads a document written with |WithDocumentChecksum| and returns an error if the trailing
+// "checksum" field doesn't match the hash of the bytes preceding it, or if anything but the closing brace of the
+// envelope and an optional line ending follows the field. The whole document is read into memory.
+func VerifyDocumentChecksum(r io.Reader) error {
+	doc, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	marker := []byte(fmt.Sprintf(`"%s":"`, checksumKey))
+	idx := bytes.LastIndex(doc, marker)
+	if idx < 0 {
+		return errors.New("document has no checksum")
+	}
+
+	val := doc[idx+len(marker):]
+	end := bytes.IndexByte(val, '"')
+	if end < 0 {
+		return errors.New("malformed document checksum")
+	}
+
+	switch string(val[end+1:]) {
+	case "}", "}\n", "}\r\n":
+	default:
+		return errors.New("document has content following its checksum")
+	}
+
+	algo, expected, ok := bytes.Cut(val[:end], []byte(":"))
+	if !ok {
+		return errors.New("malformed document checksum")
+	}
+
+	newHash, ok := checksumHashes[ChecksumAlgorithm(algo)]
+	if !ok {
+		return fmt.Errorf("unknown checksum algorithm '%s'", algo)
+	}
+
+	h := newHash()
+	h.Write(doc[:idx])
+	if actual := fmt.Sprintf("%x", h.Sum(nil)); actual != string(expected) {
+		return fmt.Errorf("document checksum mismatch: expected %s, computed %s", expected, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+// maxProfileValues is the number of distinct values tracked for a profiled column. Once a column has more, only its
+// count is kept, so the memory used by a profile is bounded by this many values per column.
+const maxProfileValues = 100
+
+// columnProfile counts the distinct values written for a column
+type columnProfile struct {
+	col    string
+	count  int
+	values map[string]int
+}
+
+func newColumnProfile(col string) *columnProfile {
+	return &columnProfile{col: col, values: make(map[string]int)}
+}
+
+// add counts a value written for the column. NULL values, which aren't written, are passed as nil and not counted.
+func (p *columnProfile) add(val interface{}) error {
+	if val == nil {
+		return nil
+	}
+	p.count++
+
+	if p.values == nil {
+		// high cardinality
+		return nil
+	}
+
+	key, err := valueText(val)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := p.values[key]; !ok && len(p.values) == maxProfileValues {
+		p.values = nil
+		return nil
+	}
+	p.values[key]++
+	return nil
+}
+
+// summary returns the value written for the profile in the envelope footer
+func (p *columnProfile) summary() interface{} {
+	if p.values == nil {
+		return struct {
+			Count           int  `json:"count"`
+			HighCardinality bool `json:"high_cardinality"`
+		}{p.count, true}
+	}
+	return struct {
+		Count  int            `json:"count"`
+		Values map[string]int `json:"values"`
+	}{p.count, p.values}
+}
@@ -0,0 +1,306 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dolthub/go-mysql-server/sql"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/row"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+	"github.com/dolthub/dolt/go/libraries/doltcore/table"
+	"github.com/dolthub/dolt/go/libraries/utils/filesys"
+	"github.com/dolthub/dolt/go/store/types"
+)
+
+// PartitionDatePlaceholder is replaced with a partition's date in the directory pattern of a date partitioned writer
+const PartitionDatePlaceholder = "{date}"
+
+// DefaultNullPartition is the partition of rows with a NULL date unless another is configured, following the Hive
+// convention
+const DefaultNullPartition = "__HIVE_DEFAULT_PARTITION__"
+
+const defaultMaxOpenPartitions = 16
+
+const partitionDateLayout = "2006-01-02"
+
+// PartitionOption configures optional behavior of a DatePartitionedWriter
+type PartitionOption func(w *DatePartitionedWriter) error
+
+// WithMaxOpenPartitions sets the maximum number of partition files held open at once. When a row is written to a
+// partition whose file isn't open and the limit has been reached, the least recently written partition's file is
+// flushed and closed, and reopened for appending if it receives more rows. The default is 16.
+func WithMaxOpenPartitions(n int) PartitionOption {
+	return func(w *DatePartitionedWriter) error {
+		if n <= 0 {
+			return fmt.Errorf("max open partitions must be positive, got %d", n)
+		}
+		w.maxOpen = n
+		return nil
+	}
+}
+
+// WithDefaultPartition sets the name of the partition that rows with a NULL date are written to. The default is
+// |DefaultNullPartition|.
+func WithDefaultPartition(name string) PartitionOption {
+	return func(w *DatePartitionedWriter) error {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid default partition name '%s'", name)
+		}
+		w.nullPartition = name
+		return nil
+	}
+}
+
+// WithPartitionWriterOptions sets the options of the json writer of each partition file. Options that require a
+// seekable destination, such as |WithLeadingRowCount|, have no effect.
+func WithPartitionWriterOptions(opts ...WriterOption) PartitionOption {
+	return func(w *DatePartitionedWriter) error {
+		w.writerOpts = opts
+		return nil
+	}
+}
+
+// DatePartitionedWriter writes rows to one json file per day, routing each row by the value of a date column. Each
+// file is a complete json document, written with the default envelope by its own RowWriter.
+type DatePartitionedWriter struct {
+	dirPattern    string
+	fs            filesys.WritableFS
+	sch           schema.Schema
+	dateCol       schema.Column
+	maxOpen       int
+	nullPartition string
+	writerOpts    []WriterOption
+
+	partitions map[string]*partition
+	// open holds the partitions whose files are open, most recently written first
+	open *list.List
+}
+
+type partition struct {
+	file *partitionFile
+	wr   *RowWriter
+	// elem is the partition's element of the open list, or nil if its file is closed
+	elem *list.Element
+}
+
+var _ table.SqlRowWriter = (*DatePartitionedWriter)(nil)
+
+// NewDatePartitionedJSONWriter returns a writer that routes each row to a file named by the day of its |dateCol|
+// value, e.g. 2024-01-15.json. Files are written to the directory |dirPattern| of |fs|, in which any occurrence of
+// |PartitionDatePlaceholder| is replaced by the date, so that e.g. "export/dt={date}" gives a Hive style layout with a
+// directory per partition. Rows with a NULL date go to a default partition, configured with |WithDefaultPartition|.
+// Every file written is closed with its footer in Close.
+//
+// Each partition with rows holds the write buffer of its writer for the life of the writer, whether or not its file is
+// open, so a small buffer size given with |WithBufferSize| saves memory when there are many partitions.
+func NewDatePartitionedJSONWriter(dirPattern string, fs filesys.WritableFS, sch schema.Schema, dateCol string, opts ...PartitionOption) (*DatePartitionedWriter, error) {
+	col, ok := sch.GetAllCols().GetByName(dateCol)
+	if !ok {
+		return nil, fmt.Errorf("partition column '%s' not found in schema", dateCol)
+	}
+	if col.TypeInfo.GetTypeIdentifier() != typeinfo.DatetimeTypeIdentifier {
+		return nil, fmt.Errorf("partition column '%s' of type %s is not a date type", dateCol, col.TypeInfo.String())
+	}
+
+	w := &DatePartitionedWriter{
+		dirPattern:    dirPattern,
+		fs:            fs,
+		sch:           sch,
+		dateCol:       col,
+		maxOpen:       defaultMaxOpenPartitions,
+		nullPartition: DefaultNullPartition,
+		partitions:    make(map[string]*partition),
+		open:          list.New(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(w); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *DatePartitionedWriter) GetSchema() schema.Schema {
+	return w.sch
+}
+
+// WriteRow writes the row given to the file of its date's partition
+func (w *DatePartitionedWriter) WriteRow(ctx context.Context, r row.Row) error {
+	if w.partitions == nil {
+		return errWriteClosed
+	}
+
+	name := w.nullPartition
+	if val, ok := r.GetColVal(w.dateCol.Tag); ok && !types.IsNull(val) {
+		name = time.Time(val.(types.Timestamp)).Format(partitionDateLayout)
+	}
+
+	p, err := w.partition(name)
+	if err != nil {
+		return err
+	}
+	return p.wr.WriteRow(ctx, r)
+}
+
+// WriteSqlRow writes the row given to the file of its date's partition
+func (w *DatePartitionedWriter) WriteSqlRow(ctx context.Context, r sql.Row) error {
+	if w.partitions == nil {
+		return errWriteClosed
+	}
+
+	name := w.nullPartition
+	if val := r[w.sch.GetAllCols().TagToIdx[w.dateCol.Tag]]; val != nil {
+		t, ok := val.(time.Time)
+		if !ok {
+			return fmt.Errorf("unexpected value of type %T for partition column '%s'", val, w.dateCol.Name)
+		}
+		name = t.Format(partitionDateLayout)
+	}
+
+	p, err := w.partition(name)
+	if err != nil {
+		return err
+	}
+	return p.wr.WriteSqlRow(ctx, r)
+}
+
+// partition returns the partition with the name given, creating it if necessary, with its file open for writing
+func (w *DatePartitionedWriter) partition(name string) (*partition, error) {
+	p, ok := w.partitions[name]
+	if !ok {
+		file := &partitionFile{fs: w.fs, path: w.partitionPath(name)}
+		wr, err := NewJSONWriter(file, w.sch, w.writerOpts...)
+		if err != nil {
+			return nil, err
+		}
+		p = &partition{file: file, wr: wr}
+		w.partitions[name] = p
+	}
+
+	if p.elem != nil {
+		w.open.MoveToFront(p.elem)
+		return p, nil
+	}
+
+	for w.open.Len() >= w.maxOpen {
+		if err := w.suspend(w.open.Back().Value.(*partition)); err != nil {
+			return nil, err
+		}
+	}
+	p.elem = w.open.PushFront(p)
+	return p, nil
+}
+
+// suspend flushes a partition's buffered rows and closes its file
+func (w *DatePartitionedWriter) suspend(p *partition) error {
+	w.open.Remove(p.elem)
+	p.elem = nil
+
+	if err := p.wr.bWr.Flush(); err != nil {
+		return err
+	}
+	return p.file.suspend()
+}
+
+func (w *DatePartitionedWriter) partitionPath(name string) string {
+	dir := strings.ReplaceAll(w.dirPattern, PartitionDatePlaceholder, name)
+	return filepath.Join(dir, name+".json")
+}
+
+// Close writes the footer of every partition file and closes them
+func (w *DatePartitionedWriter) Close(ctx context.Context) error {
+	if w.partitions == nil {
+		return errors.New("already closed")
+	}
+
+	// close the open files first, so that reopening the others never exceeds the limit
+	var names []string
+	for name := range w.partitions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := w.partitions[names[i]], w.partitions[names[j]]
+		if (pi.elem != nil) != (pj.elem != nil) {
+			return pi.elem != nil
+		}
+		return names[i] < names[j]
+	})
+
+	var firstErr error
+	for _, name := range names {
+		if err := w.partitions[name].wr.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	w.partitions = nil
+	w.open.Init()
+	return firstErr
+}
+
+// partitionFile is the destination of a partition's writer. It opens the file when it's written to, truncating it on
+// the first open and appending on any later one, so that it can be closed between writes.
+type partitionFile struct {
+	fs     filesys.WritableFS
+	path   string
+	f      io.WriteCloser
+	opened bool
+}
+
+func (pf *partitionFile) Write(p []byte) (int, error) {
+	if pf.f == nil {
+		var err error
+		if pf.opened {
+			pf.f, err = pf.fs.OpenForWriteAppend(pf.path, os.ModePerm)
+		} else {
+			if err = pf.fs.MkDirs(filepath.Dir(pf.path)); err == nil {
+				pf.f, err = pf.fs.OpenForWrite(pf.path, os.ModePerm)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		pf.opened = true
+	}
+
+	return pf.f.Write(p)
+}
+
+func (pf *partitionFile) suspend() error {
+	if pf.f == nil {
+		return nil
+	}
+	err := pf.f.Close()
+	pf.f = nil
+	return err
+}
+
+func (pf *partitionFile) Close() error {
+	return pf.suspend()
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/row"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+	"github.com/dolthub/dolt/go/libraries/utils/filesys"
+	"github.com/dolthub/dolt/go/store/types"
+)
+
+func TestDatePartitionedJSONWriter(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "day", Tag: 1, Kind: types.TimestampKind, TypeInfo: typeinfo.DateType},
+	)
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	dir := t.TempDir()
+	wr, err := NewDatePartitionedJSONWriter(filepath.Join(dir, "dt="+PartitionDatePlaceholder), filesys.LocalFS, sch, "day", WithMaxOpenPartitions(2))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i, d := range []interface{}{day(15), day(16), day(17), nil, day(15), day(17), day(15)} {
+		require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(i), d}))
+		assert.LessOrEqual(t, wr.open.Len(), 2)
+	}
+	require.NoError(t, wr.Close(ctx))
+
+	expected := map[string]string{
+		"2024-01-15":         `{"rows": [{"id":0,"day":"2024-01-15"},{"id":4,"day":"2024-01-15"},{"id":6,"day":"2024-01-15"}]}`,
+		"2024-01-16":         `{"rows": [{"id":1,"day":"2024-01-16"}]}`,
+		"2024-01-17":         `{"rows": [{"id":2,"day":"2024-01-17"},{"id":5,"day":"2024-01-17"}]}`,
+		DefaultNullPartition: `{"rows": [{"id":3}]}`,
+	}
+	for name, doc := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, "dt="+name, name+".json"))
+		require.NoError(t, err)
+		assert.Equal(t, doc, string(data))
+	}
+
+	assert.Equal(t, errWriteClosed, wr.WriteSqlRow(ctx, sql.Row{int64(7), day(15)}))
+	r, err := row.New(types.Format_Default, sch, row.TaggedValues{0: types.Int(7)})
+	require.NoError(t, err)
+	assert.Equal(t, errWriteClosed, wr.WriteRow(ctx, r))
+
+	_, err = NewDatePartitionedJSONWriter(dir, filesys.LocalFS, sch, "id")
+	assert.Error(t, err)
+}
+
+func TestDatePartitionedJSONWriterInMemFS(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "day", Tag: 1, Kind: types.TimestampKind, TypeInfo: typeinfo.DateType},
+	)
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	fs := filesys.NewInMemFS(nil, nil, "/")
+	wr, err := NewDatePartitionedJSONWriter("/export", fs, sch, "day", WithMaxOpenPartitions(1))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i, d := range []time.Time{day(15), day(16), day(15)} {
+		require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(i), d}))
+	}
+	require.NoError(t, wr.Close(ctx))
+
+	data, err := fs.ReadFile("/export/2024-01-15.json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"rows": [{"id":0,"day":"2024-01-15"},{"id":2,"day":"2024-01-15"}]}`, string(data))
+	data, err = fs.ReadFile("/export/2024-01-16.json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"rows": [{"id":1,"day":"2024-01-16"}]}`, string(data))
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// prevHashField is the name of the field holding the hash of the previous row when hash chaining is enabled
+const prevHashField = "_prev_hash"
+
+// DefaultHashChainGenesis is the previous hash of the first row of a hash chain unless another is configured
+var DefaultHashChainGenesis = strings.Repeat("0", sha256.Size*2)
+
+// rowHash returns the hex encoded SHA-256 of the bytes of a row as written
+func rowHash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// VerifyHashChain reads a document written with |WithHashChain|, either with the default "rows" envelope or as line
+// delimited objects, and returns an error identifying the first row whose "_prev_hash" isn't the hash of the previous
+// row's bytes, or |genesis| for the first row. Schema change and batch end markers between rows are skipped.
+func VerifyHashChain(r io.Reader, genesis string) error {
+	return VerifyHashChainWithKey(r, genesis, "rows")
+}
+
+// VerifyHashChainWithKey verifies the hash chain of a document like |VerifyHashChain|, for a document whose envelope
+// holds its rows under |rowsKey| rather than "rows", like one written by |NewJSONWriterWithKey|
+func VerifyHashChainWithKey(r io.Reader, genesis, rowsKey string) error {
+	rows, err := readChainRows(r, rowsKey)
+	if err != nil {
+		return err
+	}
+
+	prev := genesis
+	for i, raw := range rows {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if isMarker(fields) {
+			continue
+		}
+
+		var prevHash string
+		if err := json.Unmarshal(fields[prevHashField], &prevHash); err != nil {
+			return fmt.Errorf("row %d has no valid %s field", i, prevHashField)
+		}
+		if prevHash != prev {
+			return fmt.Errorf("hash chain broken at row %d: expected %s %s, found %s", i, prevHashField, prev, prevHash)
+		}
+
+		prev = rowHash(raw)
+	}
+
+	return nil
+}
+
+// readChainRows returns the exact bytes of each object in a document, which is either an envelope holding them under
+// |rowsKey| or a stream of line delimited objects
+func readChainRows(r io.Reader, rowsKey string) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(r)
+
+	var rows []json.RawMessage
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rows = append(rows, raw)
+	}
+
+	if len(rows) == 1 {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(rows[0], &envelope); err == nil {
+			if rowsField, ok := envelope[rowsKey]; ok {
+				var envRows []json.RawMessage
+				if err := json.Unmarshal(rowsField, &envRows); err != nil {
+					return nil, err
+				}
+				return envRows, nil
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// isMarker returns whether an object of line delimited output is a marker written between rows
+func isMarker(fields map[string]json.RawMessage) bool {
+	if len(fields) != 1 {
+		return false
+	}
+	_, batchEnd := fields["_batch_end"]
+	_, schemaChange := fields["schema_change"]
+	return batchEnd || schemaChange
+}
@@ -37,7 +37,7 @@ type JsonDiffWriter struct {
 var _ diff.SqlRowDiffWriter = (*JsonDiffWriter)(nil)
 
 func NewJsonDiffWriter(wr io.WriteCloser, outSch schema.Schema) (*JsonDiffWriter, error) {
-	writer, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(wr), outSch, "", "", "")
+	writer, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(wr), outSch, "", "", "", WithEnvelopeValidation(false), withSortedFields())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,157 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MultipartPartSize is the size of each part uploaded by a MultipartUploadWriter, other than the last. S3 requires
+// all parts but the last to be at least 5MB.
+var MultipartPartSize = 5 * 1024 * 1024
+
+// MultipartUploader is the subset of the S3 API used by MultipartUploadWriter. It's satisfied by *s3.S3.
+type MultipartUploader interface {
+	CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error)
+	CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// MultipartUploadWriter is an io.WriteCloser that streams everything written to it into an S3 object using a
+// multipart upload. Writes are buffered into parts of |MultipartPartSize| bytes, each uploaded as it fills, and the
+// upload is completed by Close. If any part fails to upload the multipart upload is aborted, and that error is
+// returned from the failing call and every call after it. It can be used as the destination of |NewJSONWriter|.
+type MultipartUploadWriter struct {
+	uploader MultipartUploader
+	bucket   string
+	key      string
+	uploadID string
+	buf      bytes.Buffer
+	parts    []*s3.CompletedPart
+	err      error
+	closed   bool
+}
+
+var _ io.WriteCloser = (*MultipartUploadWriter)(nil)
+
+// NewMultipartUploadWriter starts a multipart upload to |bucket| and |key| and returns a writer for its contents
+func NewMultipartUploadWriter(uploader MultipartUploader, bucket, key string) (*MultipartUploadWriter, error) {
+	result, err := uploader.CreateMultipartUploadWithContext(aws.BackgroundContext(), &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultipartUploadWriter{
+		uploader: uploader,
+		bucket:   bucket,
+		key:      key,
+		uploadID: *result.UploadId,
+	}, nil
+}
+
+// Write buffers |p|, uploading a part each time the buffer fills
+func (w *MultipartUploadWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("write on closed multipart upload writer")
+	}
+
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() >= MultipartPartSize {
+		if err := w.uploadPart(w.buf.Next(MultipartPartSize)); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Close uploads any remaining buffered bytes as the final part and completes the upload
+func (w *MultipartUploadWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errors.New("already closed")
+	}
+	w.closed = true
+
+	// an upload needs at least one part, even if nothing was written
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(w.buf.Next(w.buf.Len())); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.uploader.CompleteMultipartUploadWithContext(aws.BackgroundContext(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		return w.abort(err)
+	}
+
+	return nil
+}
+
+func (w *MultipartUploadWriter) uploadPart(data []byte) error {
+	partNum := int64(len(w.parts) + 1) // parts are 1-indexed
+	result, err := w.uploader.UploadPartWithContext(aws.BackgroundContext(), &s3.UploadPartInput{
+		Bucket:        aws.String(w.bucket),
+		Key:           aws.String(w.key),
+		UploadId:      aws.String(w.uploadID),
+		PartNumber:    aws.Int64(partNum),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return w.abort(fmt.Errorf("error uploading part %d: %w", partNum, err))
+	}
+
+	w.parts = append(w.parts, &s3.CompletedPart{ETag: result.ETag, PartNumber: aws.Int64(partNum)})
+	return nil
+}
+
+// abort aborts the multipart upload after a failure, so that S3 discards the parts already uploaded
+func (w *MultipartUploadWriter) abort(cause error) error {
+	w.err = cause
+	w.buf.Reset()
+
+	_, err := w.uploader.AbortMultipartUploadWithContext(aws.BackgroundContext(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadID),
+	})
+	if err != nil {
+		w.err = fmt.Errorf("%w; additionally the multipart upload could not be aborted: %s", cause, err.Error())
+	}
+
+	return w.err
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+	"github.com/dolthub/dolt/go/store/types"
+)
+
+type fakeUploader struct {
+	parts     map[int64][]byte
+	completed []byte
+	aborted   bool
+	failPart  int64
+}
+
+func (f *fakeUploader) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	f.parts = make(map[int64][]byte)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+}
+
+func (f *fakeUploader) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	if *input.PartNumber == f.failPart {
+		return nil, errors.New("connection reset")
+	}
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.parts[*input.PartNumber] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", *input.PartNumber))}, nil
+}
+
+func (f *fakeUploader) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	for _, p := range input.MultipartUpload.Parts {
+		if *p.ETag != fmt.Sprintf("etag-%d", *p.PartNumber) {
+			return nil, errors.New("bad etag")
+		}
+		f.completed = append(f.completed, f.parts[*p.PartNumber]...)
+	}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeUploader) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestMultipartUploadWriter(t *testing.T) {
+	defer func(size int) { MultipartPartSize = size }(MultipartPartSize)
+	MultipartPartSize = 16
+
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}}
+	expected := writeSqlRows(t, sch, rows)
+
+	uploader := &fakeUploader{}
+	mpw, err := NewMultipartUploadWriter(uploader, "bucket", "export.json")
+	require.NoError(t, err)
+	wr, err := NewJSONWriter(mpw, sch)
+	require.NoError(t, err)
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+
+	assert.Equal(t, expected, string(uploader.completed))
+	assert.Len(t, uploader.parts, (len(expected)+MultipartPartSize-1)/MultipartPartSize)
+	assert.False(t, uploader.aborted)
+
+	uploader = &fakeUploader{failPart: 2}
+	mpw, err = NewMultipartUploadWriter(uploader, "bucket", "export.json")
+	require.NoError(t, err)
+	_, err = mpw.Write(make([]byte, 40))
+	assert.Error(t, err)
+	assert.True(t, uploader.aborted)
+	_, err = mpw.Write([]byte("more"))
+	assert.Error(t, err)
+	assert.Error(t, mpw.Close())
+	assert.Nil(t, uploader.completed)
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+)
+
+// orderedRow is the values of a row along with the order their fields are written in. Fields not in |keys| are
+// written after the others in sorted order.
+type orderedRow struct {
+	keys       []string
+	vals       map[string]interface{}
+	escapeHTML bool
+}
+
+// fieldEncodeError is the error encoding the value of one field of a row
+type fieldEncodeError struct {
+	field string
+	err   error
+}
+
+func (e *fieldEncodeError) Error() string {
+	return fmt.Sprintf("failed to encode field '%s': %s", e.field, e.err.Error())
+}
+
+func (e *fieldEncodeError) Unwrap() error {
+	return e.err
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	written := 0
+	writeField := func(key string, val interface{}) error {
+		if written > 0 {
+			buf.WriteByte(',')
+		}
+		written++
+
+		k, err := encodeJSON(key, r.escapeHTML)
+		if err != nil {
+			return err
+		}
+		v, err := encodeJSON(val, r.escapeHTML)
+		if err != nil {
+			return &fieldEncodeError{field: key, err: err}
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+		return nil
+	}
+
+	ordered := make(map[string]struct{}, len(r.keys))
+	for _, key := range r.keys {
+		ordered[key] = struct{}{}
+		if val, ok := r.vals[key]; ok {
+			if err := writeField(key, val); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if written < len(r.vals) {
+		var rest []string
+		for key := range r.vals {
+			if _, ok := ordered[key]; !ok {
+				rest = append(rest, key)
+			}
+		}
+		sort.Strings(rest)
+		for _, key := range rest {
+			if err := writeField(key, r.vals[key]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalCBOR encodes the row as a CBOR map, whose keys are always sorted by the deterministic encoding
+func (r orderedRow) MarshalCBOR() ([]byte, error) {
+	return cborEncMode.Marshal(r.vals)
+}
+
+// fieldOrder returns the order of the fields of rows of the schema given: the "_type" discriminator, then the columns
+// in schema order, or the order of the writer's projection, with each coalesce target following the last of its
+// sources, and then the fields added by the writer. Column names are transformed by the writer's key name function, if
+// it has one. Orders are cached per schema. Writers configured to sort their fields have no order, so that every field
+// is written in sorted order.
+func (j *RowWriter) fieldOrder(sch schema.Schema) []string {
+	if j.sortFields {
+		return nil
+	}
+	if keys, ok := j.fieldOrders[sch]; ok {
+		return keys
+	}
+
+	var cols []string
+	if j.projection != nil {
+		cols = append(cols, j.projection...)
+	} else {
+		_ = sch.GetAllCols().Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+			cols = append(cols, col.Name)
+			return false, nil
+		})
+	}
+
+	for _, c := range j.coalesces {
+		if containsStr(cols, c.target) {
+			// the target replaces a dropped source of the same name
+			continue
+		}
+
+		last := -1
+		for i, name := range cols {
+			if containsStr(c.sources, name) {
+				last = i
+			}
+		}
+		cols = append(cols[:last+1], append([]string{c.target}, cols[last+1:]...)...)
+	}
+
+	keys := []string{typeField}
+	for _, name := range cols {
+		if j.keyNameFunc != nil {
+			name = j.keyNameFunc(name)
+		}
+		keys = append(keys, name)
+	}
+	keys = append(keys, seqField)
+	if j.surrogateIDField != "" {
+		keys = append(keys, j.surrogateIDField)
+	}
+	keys = append(keys, prevHashField)
+
+	if j.fieldOrders == nil {
+		j.fieldOrders = make(map[schema.Schema][]string)
+	}
+	j.fieldOrders[sch] = keys
+	return keys
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// limitedWriter waits on a token bucket before each write it passes to the underlying writer, taking one token per
+// byte. Writes larger than the limiter's burst are split into burst sized pieces.
+type limitedWriter struct {
+	wr  io.Writer
+	lim *rate.Limiter
+	// ctx is the context of the RowWriter call currently writing, and is used to cancel a pending wait. Between calls
+	// it's context.Background(), e.g. for Flush, so a wait never uses the context of a call that has returned.
+	ctx context.Context
+}
+
+func newLimitedWriter(wr io.Writer, lim *rate.Limiter) *limitedWriter {
+	return &limitedWriter{wr: wr, lim: lim, ctx: context.Background()}
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.lim.Limit() == rate.Inf {
+		return lw.wr.Write(p)
+	}
+
+	burst := lw.lim.Burst()
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > burst {
+			n = burst
+		}
+
+		if err := lw.lim.WaitN(lw.ctx, n); err != nil {
+			return written, err
+		}
+
+		m, err := lw.wr.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
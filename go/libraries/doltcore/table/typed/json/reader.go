@@ -15,16 +15,23 @@
 package json
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
+	"strings"
 
-	"github.com/bcicen/jstream"
 	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 
 	"github.com/dolthub/dolt/go/libraries/doltcore/row"
 	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
 	"github.com/dolthub/dolt/go/libraries/doltcore/table"
 	"github.com/dolthub/dolt/go/libraries/utils/filesys"
 	"github.com/dolthub/dolt/go/store/types"
@@ -32,34 +39,146 @@ import (
 
 var ReadBufSize = 256 * 1024
 
+// JSONReader reads the rows of a JSON document holding them in an array under a "rows" key, like the documents written
+// by |NewJSONWriter|. The array is streamed a row at a time, and any other fields of the document, such as those of a
+// writer's footer, are skipped. A document with no "rows" field is an error, even if it holds its rows in an array
+// under another key, which must be given with |WithRowsKey|. Values are converted back to those of the writer's column
+// types, including the ones that the writer writes in another form, e.g. decimals written as strings, SETs written as
+// arrays, the values of |WithTypeTagsForAmbiguous| and |WithDecimalVerbose|, and BIT(1) values written as booleans
+// with |BitBoolean|. These forms aren't read back:
+//   - geometry values written as GeoJSON objects, which are rejected, or as WKT strings, which fail to convert
+//   - BIT values written with |BitHexString|, which fail to convert
+//   - fields written as nested objects with |WithNestedColumnPaths|, which aren't columns of the schema
 type JSONReader struct {
-	vrw        types.ValueReadWriter
-	closer     io.Closer
-	sch        schema.Schema
-	jsonStream *jstream.Decoder
-	rowChan    chan *jstream.MetaValue
-	sampleRow  sql.Row
+	vrw       types.ValueReadWriter
+	closer    io.Closer
+	sch       schema.Schema
+	dec       *json.Decoder
+	sampleRow sql.Row
+	rowsRead  int
+
+	// inRows is set once the decoder is positioned within the rows array, and done once the document has been read
+	inRows bool
+	done   bool
+	// lineDelimited is set for newline delimited JSON, which has a row object per line and no enclosing document
+	lineDelimited bool
+
+	rowsKey        string
+	jsonSchema     *jsonschema.Schema
+	emptyStrPolicy EmptyStringPolicy
+	binaryEncoding BinaryEncoding
+}
+
+// ReaderOption configures optional behavior of a JSONReader
+type ReaderOption func(r *JSONReader) error
+
+// EmptyStringPolicy determines how an empty JSON string is read for a nullable text column
+type EmptyStringPolicy int
+
+const (
+	// EmptyStringAsEmpty reads an empty JSON string as an empty string
+	EmptyStringAsEmpty EmptyStringPolicy = iota
+	// EmptyStringAsNull reads an empty JSON string as NULL. It's the counterpart of a writer created with
+	// |WithNullStringsAsEmpty|.
+	EmptyStringAsNull
+)
+
+// WithEmptyStringPolicy sets how empty strings are read for nullable text columns. The default, EmptyStringAsEmpty,
+// keeps them as empty strings. Columns that aren't nullable always read empty strings as empty strings.
+func WithEmptyStringPolicy(policy EmptyStringPolicy) ReaderOption {
+	return func(r *JSONReader) error {
+		if policy != EmptyStringAsEmpty && policy != EmptyStringAsNull {
+			return fmt.Errorf("unknown empty string policy %d", policy)
+		}
+		r.emptyStrPolicy = policy
+		return nil
+	}
+}
+
+// WithJSONSchemaValidation validates each row object against the JSON Schema given before it's converted to a row.
+// Rows that don't conform are rejected with an error naming the failing fields.
+func WithJSONSchemaValidation(schemaBytes []byte) ReaderOption {
+	return func(r *JSONReader) error {
+		const url = "row_schema.json"
+		compiler := jsonschema.NewCompiler()
+		if err := compiler.AddResource(url, bytes.NewReader(schemaBytes)); err != nil {
+			return fmt.Errorf("invalid JSON Schema: %w", err)
+		}
+
+		jsonSch, err := compiler.Compile(url)
+		if err != nil {
+			return fmt.Errorf("invalid JSON Schema: %w", err)
+		}
+
+		r.jsonSchema = jsonSch
+		return nil
+	}
+}
+
+// WithRowsKey reads the rows of a document that holds them under |key| rather than "rows", like one written by
+// |NewJSONWriterWithKey|
+func WithRowsKey(key string) ReaderOption {
+	return func(r *JSONReader) error {
+		if key == "" {
+			return errors.New("json rows key must not be empty")
+		}
+		r.rowsKey = key
+		return nil
+	}
+}
+
+// WithBinaryDecoding sets how the values of BINARY, VARBINARY and BLOB columns are decoded, which must match the
+// encoding the document was written with. The default is |BinaryRaw|.
+func WithBinaryDecoding(enc BinaryEncoding) ReaderOption {
+	return func(r *JSONReader) error {
+		if enc != BinaryRaw && enc != BinaryBase64 {
+			return fmt.Errorf("unknown binary encoding '%s'", enc)
+		}
+		r.binaryEncoding = enc
+		return nil
+	}
 }
 
 var _ table.SqlTableReader = (*JSONReader)(nil)
+var _ table.SqlRowReader = (*JSONReader)(nil)
 
-func OpenJSONReader(vrw types.ValueReadWriter, path string, fs filesys.ReadableFS, sch schema.Schema) (*JSONReader, error) {
+func OpenJSONReader(vrw types.ValueReadWriter, path string, fs filesys.ReadableFS, sch schema.Schema, opts ...ReaderOption) (*JSONReader, error) {
 	r, err := fs.OpenForRead(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewJSONReader(vrw, r, sch)
+	return NewJSONReader(vrw, r, sch, opts...)
 }
 
-func NewJSONReader(vrw types.ValueReadWriter, r io.ReadCloser, sch schema.Schema) (*JSONReader, error) {
+func NewJSONReader(vrw types.ValueReadWriter, r io.ReadCloser, sch schema.Schema, opts ...ReaderOption) (*JSONReader, error) {
 	if sch == nil {
 		return nil, errors.New("schema must be provided to JsonReader")
 	}
 
-	decoder := jstream.NewDecoder(r, 2) // extract JSON values at a depth level of 1
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
 
-	return &JSONReader{vrw: vrw, closer: r, sch: sch, jsonStream: decoder}, nil
+	jr := &JSONReader{vrw: vrw, closer: r, sch: sch, dec: dec, rowsKey: "rows", binaryEncoding: BinaryRaw}
+	for _, opt := range opts {
+		if err := opt(jr); err != nil {
+			return nil, err
+		}
+	}
+
+	return jr, nil
+}
+
+// NewNDJSONReader returns a new reader of newline delimited JSON rows, like those written by |NewNDJSONWriter|. The
+// marker lines written between rows, such as the {"_batch_end":true} lines of a writer with |WithBatchMarkers|, are
+// skipped.
+func NewNDJSONReader(vrw types.ValueReadWriter, r io.ReadCloser, sch schema.Schema, opts ...ReaderOption) (*JSONReader, error) {
+	jr, err := NewJSONReader(vrw, r, sch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	jr.lineDelimited = true
+	return jr, nil
 }
 
 // Close should release resources being held
@@ -99,32 +218,205 @@ func (r *JSONReader) ReadSqlRow(ctx context.Context) (sql.Row, error) {
 		return ret, nil
 	}
 
-	if r.rowChan == nil {
-		r.rowChan = r.jsonStream.Stream()
+	if r.done {
+		return nil, io.EOF
+	}
+
+	raw, err := r.nextRow()
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+	r.rowsRead++
+
+	if r.jsonSchema != nil {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var val interface{}
+		if err := dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		if err := r.jsonSchema.Validate(val); err != nil {
+			return nil, schemaValidationError(r.rowsRead, err)
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("row %d is not a JSON object: %w", r.rowsRead, err)
+	}
+
+	return r.convToSqlRow(fields)
+}
+
+// nextRow returns the next row object of the input, or io.EOF once all of them have been read
+func (r *JSONReader) nextRow() (json.RawMessage, error) {
+	if r.lineDelimited {
+		for {
+			var raw json.RawMessage
+			if err := r.dec.Decode(&raw); err != nil {
+				if err == io.EOF {
+					r.done = true
+				}
+				return nil, err
+			}
+
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(raw, &fields) == nil && isMarker(fields) {
+				continue
+			}
+			return raw, nil
+		}
+	}
+
+	if !r.inRows {
+		if err := r.seekRows(); err != nil {
+			return nil, err
+		}
 	}
 
-	metaRow, ok := <-r.rowChan
-	if !ok {
-		if r.jsonStream.Err() != nil {
-			return nil, r.jsonStream.Err()
+	if !r.dec.More() {
+		if err := r.finishDocument(); err != nil {
+			return nil, err
 		}
 		return nil, io.EOF
 	}
 
-	return r.convToSqlRow(metaRow.Value.(map[string]interface{}))
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
 }
 
-func (r *JSONReader) convToSqlRow(rowMap map[string]interface{}) (sql.Row, error) {
+// invalidCharRe matches the message of a json.SyntaxError for an unexpected character
+var invalidCharRe = regexp.MustCompile(`^invalid character ('.+') (.+)$`)
+
+// syntaxError rewrites the error of a malformed document in the form "invalid character <context>: '<char>' at offset
+// <n>", so that the message names the byte at which the document is malformed and doesn't depend on how the decoder
+// words it
+func syntaxError(err error) error {
+	var synErr *json.SyntaxError
+	if !errors.As(err, &synErr) {
+		return err
+	}
+
+	msg := synErr.Error()
+	if m := invalidCharRe.FindStringSubmatch(msg); m != nil {
+		msg = fmt.Sprintf("invalid character %s: %s", m[2], m[1])
+	}
+	return fmt.Errorf("%s at offset %d", msg, synErr.Offset)
+}
+
+// seekRows reads the document up to the start of its rows array, skipping any fields ahead of it
+func (r *JSONReader) seekRows() error {
+	if err := r.expectDelim('{'); err != nil {
+		return err
+	}
+
+	for r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == r.rowsKey {
+			if err := r.expectDelim('['); err != nil {
+				return fmt.Errorf("field '%s': %w", r.rowsKey, err)
+			}
+			r.inRows = true
+			return nil
+		}
+
+		var skipped json.RawMessage
+		if err := r.dec.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("JSON document has no '%s' field", r.rowsKey)
+}
+
+// finishDocument reads the rest of the document following the rows array, skipping any fields in it
+func (r *JSONReader) finishDocument() error {
+	if err := r.expectDelim(']'); err != nil {
+		return err
+	}
+
+	for r.dec.More() {
+		if _, err := r.dec.Token(); err != nil {
+			return err
+		}
+		var skipped json.RawMessage
+		if err := r.dec.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+
+	if err := r.expectDelim('}'); err != nil {
+		return err
+	}
+	r.done = true
+	return nil
+}
+
+func (r *JSONReader) expectDelim(delim json.Delim) error {
+	tok, err := r.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected '%s' in JSON document, found %v", delim, tok)
+	}
+	return nil
+}
+
+// schemaValidationError describes each failing field of a row that didn't validate against the reader's JSON Schema
+func schemaValidationError(rowNum int, err error) error {
+	var valErr *jsonschema.ValidationError
+	if !errors.As(err, &valErr) {
+		return fmt.Errorf("row %d: %w", rowNum, err)
+	}
+
+	var failures []string
+	var collect func(ve *jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			field := ve.InstanceLocation
+			if field == "" {
+				field = "/"
+			}
+			failures = append(failures, fmt.Sprintf("%s: %s", field, ve.Message))
+		}
+		for _, cause := range ve.Causes {
+			collect(cause)
+		}
+	}
+	collect(valErr)
+
+	return fmt.Errorf("row %d does not match JSON Schema: %s", rowNum, strings.Join(failures, "; "))
+}
+
+func (r *JSONReader) convToSqlRow(fields map[string]json.RawMessage) (sql.Row, error) {
 	allCols := r.sch.GetAllCols()
 
 	ret := make(sql.Row, allCols.Size())
-	for k, v := range rowMap {
+	for k, raw := range fields {
 		col, ok := allCols.GetByName(k)
 		if !ok {
 			return nil, fmt.Errorf("column %s not found in schema", k)
 		}
 
-		v, err := col.TypeInfo.ToSqlType().Convert(v)
+		v, err := r.columnValue(col, raw)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if v == "" && r.emptyStrPolicy == EmptyStringAsNull && col.IsNullable() && isTextColumn(col) {
+			continue
+		}
+
+		v, err = col.TypeInfo.ToSqlType().Convert(v)
 		if err != nil {
 			return nil, err
 		}
@@ -135,3 +427,90 @@ func (r *JSONReader) convToSqlRow(rowMap map[string]interface{}) (sql.Row, error
 
 	return ret, nil
 }
+
+// columnValue decodes the JSON value of a column, undoing the conversions the writer makes to values of the column's
+// type, into a value that the column's SQL type converts
+func (r *JSONReader) columnValue(col schema.Column, raw json.RawMessage) (interface{}, error) {
+	if col.TypeInfo.GetTypeIdentifier() == typeinfo.JSONTypeIdentifier {
+		if string(raw) == "null" {
+			// a NULL column value, as the JSON null literal is indistinguishable from it
+			return nil, nil
+		}
+		// the column's type parses the nested document itself
+		return []byte(raw), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return r.decodedColumnValue(col, v)
+}
+
+func (r *JSONReader) decodedColumnValue(col schema.Column, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		// integers are converted exactly, and other numbers from their text
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, nil
+		}
+		return string(v), nil
+
+	case string:
+		if r.binaryEncoding == BinaryBase64 && isBinaryColumn(col) {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("column '%s' holds invalid base64: %w", col.Name, err)
+			}
+			return string(b), nil
+		}
+		return v, nil
+
+	case []interface{}:
+		// a SET written as an array of its members
+		members := make([]string, len(v))
+		for i, m := range v {
+			str, ok := m.(string)
+			if !ok {
+				return nil, fmt.Errorf("column '%s' holds an array with a member of type %T", col.Name, m)
+			}
+			members[i] = str
+		}
+		return strings.Join(members, ","), nil
+
+	case map[string]interface{}:
+		// a value tagged with its type, or a verbose decimal
+		if val, ok := v["value"]; ok {
+			return r.decodedColumnValue(col, val)
+		}
+		return nil, fmt.Errorf("column '%s' holds an unexpected object", col.Name)
+	}
+
+	return v, nil
+}
+
+// isBinaryColumn returns whether |col| holds binary strings, which the writer may encode
+func isBinaryColumn(col schema.Column) bool {
+	switch col.TypeInfo.GetTypeIdentifier() {
+	case typeinfo.InlineBlobTypeIdentifier, typeinfo.VarBinaryTypeIdentifier:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTextColumn returns whether |col| holds character strings, as opposed to binary or structured values
+func isTextColumn(col schema.Column) bool {
+	switch col.TypeInfo.GetTypeIdentifier() {
+	case typeinfo.VarStringTypeIdentifier, typeinfo.BlobStringTypeIdentifier:
+		return true
+	default:
+		return false
+	}
+}
@@ -15,12 +15,16 @@
 package json
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/dolthub/go-mysql-server/enginetest"
 	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/vitess/go/sqltypes"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -29,6 +33,7 @@ import (
 	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
 	"github.com/dolthub/dolt/go/libraries/doltcore/sqle/sqlutil"
 	"github.com/dolthub/dolt/go/libraries/utils/filesys"
+	"github.com/dolthub/dolt/go/libraries/utils/iohelp"
 	"github.com/dolthub/dolt/go/store/types"
 )
 
@@ -173,6 +178,315 @@ func TestReaderBadJson(t *testing.T) {
 		}
 	}
 	assert.NotEqual(t, io.EOF, err)
+	assert.EqualError(t, err, "invalid character after object key:value pair: 'b' at offset 84")
+}
+
+func TestReaderJSONSchemaValidation(t *testing.T) {
+	testJSON := `{"rows": [{"id": 0, "name": "tim"}, {"id": "one", "name": 7}]}`
+	rowSchema := `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"name": {"type": "string"}
+		},
+		"required": ["id"]
+	}`
+
+	fs := filesys.EmptyInMemFS("/")
+	require.NoError(t, fs.WriteFile("file.json", []byte(testJSON)))
+
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	))
+	require.NoError(t, err)
+
+	vrw := types.NewMemoryValueStore()
+	_, err = OpenJSONReader(vrw, "file.json", fs, sch, WithJSONSchemaValidation([]byte(`{"type": 5}`)))
+	assert.Error(t, err)
+
+	reader, err := OpenJSONReader(vrw, "file.json", fs, sch, WithJSONSchemaValidation([]byte(rowSchema)))
+	require.NoError(t, err)
+
+	r, err := reader.ReadSqlRow(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sql.Row{int64(0), "tim"}, r)
+
+	_, err = reader.ReadSqlRow(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2")
+	assert.Contains(t, err.Error(), "/id")
+	assert.Contains(t, err.Error(), "/name")
+}
+
+func TestReaderEmptyStringPolicy(t *testing.T) {
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "nickname", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "name", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType,
+			Constraints: []schema.ColConstraint{schema.NotNullConstraint{}}},
+	))
+	require.NoError(t, err)
+
+	rows := []sql.Row{
+		{int64(0), "timmy", "tim"},
+		{int64(1), nil, ""},
+	}
+
+	roundTrip := func(wrOpts []WriterOption, rdOpts ...ReaderOption) (string, []sql.Row) {
+		var buf bytes.Buffer
+		wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, wrOpts...)
+		require.NoError(t, err)
+		for _, r := range rows {
+			require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+		}
+		require.NoError(t, wr.Close(context.Background()))
+
+		rd, err := NewJSONReader(types.NewMemoryValueStore(), io.NopCloser(bytes.NewReader(buf.Bytes())), sch, rdOpts...)
+		require.NoError(t, err)
+		var read []sql.Row
+		for {
+			r, err := rd.ReadSqlRow(context.Background())
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			read = append(read, r)
+		}
+		return buf.String(), read
+	}
+
+	doc, read := roundTrip(nil)
+	assert.Equal(t, `{"rows": [{"id":0,"nickname":"timmy","name":"tim"},{"id":1,"name":""}]}`, doc)
+	assert.Equal(t, rows, read)
+
+	doc, read = roundTrip([]WriterOption{WithNullStringsAsEmpty(true)}, WithEmptyStringPolicy(EmptyStringAsNull))
+	assert.Equal(t, `{"rows": [{"id":0,"nickname":"timmy","name":"tim"},{"id":1,"nickname":"","name":""}]}`, doc)
+	assert.Equal(t, rows, read)
+
+	// without the matching reader policy, NULLs written as empty strings come back as empty strings
+	_, read = roundTrip([]WriterOption{WithNullStringsAsEmpty(true)}, WithEmptyStringPolicy(EmptyStringAsEmpty))
+	assert.Equal(t, []sql.Row{{int64(0), "timmy", "tim"}, {int64(1), "", ""}}, read)
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	typeInfo := func(sqlType sql.Type) typeinfo.TypeInfo {
+		ti, err := typeinfo.FromSqlType(sqlType)
+		require.NoError(t, err)
+		return ti
+	}
+	cols := []schema.Column{
+		{Name: "id", Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		{Name: "name", Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		{Name: "big", Kind: types.UintKind, TypeInfo: typeinfo.Uint64Type},
+		{Name: "price", Kind: types.DecimalKind, TypeInfo: typeInfo(sql.MustCreateDecimalType(20, 4))},
+		{Name: "score", Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+		{Name: "active", Kind: types.IntKind, TypeInfo: typeinfo.BoolType},
+		{Name: "created", Kind: types.TimestampKind, TypeInfo: typeinfo.DatetimeType},
+		{Name: "day", Kind: types.TimestampKind, TypeInfo: typeinfo.DateType},
+		{Name: "duration", Kind: types.IntKind, TypeInfo: typeinfo.TimeType},
+		{Name: "yr", Kind: types.IntKind, TypeInfo: typeinfo.YearType},
+		{Name: "size", Kind: types.UintKind, TypeInfo: typeInfo(sql.MustCreateEnumType([]string{"s", "m", "l"}, sql.Collation_Default))},
+		{Name: "tags", Kind: types.UintKind, TypeInfo: typeInfo(sql.MustCreateSetType([]string{"a", "b", "c"}, sql.Collation_Default))},
+		{Name: "data", Kind: types.InlineBlobKind, TypeInfo: typeInfo(sql.MustCreateBinary(sqltypes.VarBinary, 16))},
+		{Name: "doc", Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	}
+	for i := range cols {
+		cols[i].Tag = uint64(i)
+	}
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(cols...))
+	require.NoError(t, err)
+
+	// rows of the values the column types convert to, which is what the reader returns
+	literals := [][]interface{}{
+		{1, "tim", uint64(math.MaxUint64), "12345678901234.5678", 1.5, true, "2024-01-15 03:04:05", "2024-01-15", "-12:34:56.5", 2024, "m", "a,c", "\xff\x00a", `{"a": [1, "x"], "b": null}`},
+		{2, "", uint64(0), "-0.0001", math.Inf(-1), false, "1970-01-01 00:00:00", "0000-00-00", "00:00:00", 1999, "s", "", "", `"just a string"`},
+		{3, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+	}
+	var rows []sql.Row
+	for _, lits := range literals {
+		r := make(sql.Row, len(lits))
+		for i, lit := range lits {
+			if lit != nil {
+				r[i], err = cols[i].TypeInfo.ToSqlType().Convert(lit)
+				require.NoError(t, err)
+			}
+		}
+		rows = append(rows, r)
+	}
+
+	roundTrip := func(wrOpts []WriterOption, rdOpts ...ReaderOption) []sql.Row {
+		var buf bytes.Buffer
+		wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, wrOpts...)
+		require.NoError(t, err)
+		for _, r := range rows {
+			require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+		}
+		require.NoError(t, wr.Close(context.Background()))
+
+		rd, err := NewJSONReader(types.NewMemoryValueStore(), io.NopCloser(&buf), sch, rdOpts...)
+		require.NoError(t, err)
+		var read []sql.Row
+		for {
+			r, err := rd.ReadSqlRow(context.Background())
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			read = append(read, r)
+		}
+		return read
+	}
+
+	allOpts := []WriterOption{
+		WithNonFiniteFloats(NonFiniteAsString),
+		WithSetsAsArrays(true),
+		WithEnumsAsIndexes(true),
+		WithBinaryEncoding(BinaryBase64),
+		WithTypeTagsForAmbiguous(true),
+		WithFooterRowCount(true),
+		WithColumnProfile([]string{"name"}),
+		WithLossinessWarnings(true),
+	}
+	assert.Equal(t, rows, roundTrip(allOpts, WithBinaryDecoding(BinaryBase64)))
+	// raw binary values only round trip if they're valid UTF-8
+	assert.Equal(t, rows[1:], roundTrip([]WriterOption{WithDecimalsAsNumbers(true), WithNonFiniteFloats(NonFiniteAsString)})[1:])
+	assert.Equal(t, rows, roundTrip([]WriterOption{
+		WithDecimalVerbose(true),
+		WithIndent("  "),
+		WithNonFiniteFloats(NonFiniteAsString),
+		WithBinaryEncoding(BinaryBase64),
+	}, WithBinaryDecoding(BinaryBase64)))
+}
+
+func TestReaderUnsupportedForms(t *testing.T) {
+	bitType, err := typeinfo.FromSqlType(sql.MustCreateBitType(1))
+	require.NoError(t, err)
+	cols := []schema.Column{
+		{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		{Name: "pt", Tag: 1, Kind: types.PointKind, TypeInfo: typeinfo.PointType},
+		{Name: "flag", Tag: 2, Kind: types.UintKind, TypeInfo: bitType},
+		{Name: "address.city", Tag: 3, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	}
+
+	// each column holds a value of a form the reader doesn't read back, and every other column is NULL
+	roundTrip := func(col schema.Column, val interface{}, opts ...WriterOption) (sql.Row, error) {
+		sch, err := schema.SchemaFromCols(schema.NewColCollection(cols[0], col))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, opts...)
+		require.NoError(t, err)
+		require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1), val}))
+		require.NoError(t, wr.Close(context.Background()))
+
+		rd, err := NewJSONReader(types.NewMemoryValueStore(), io.NopCloser(&buf), sch)
+		require.NoError(t, err)
+		return rd.ReadSqlRow(context.Background())
+	}
+	unsupported := func(col schema.Column, val interface{}, opts ...WriterOption) {
+		_, err := roundTrip(col, val, opts...)
+		assert.Error(t, err)
+	}
+	supported := func(col schema.Column, val interface{}, opts ...WriterOption) {
+		r, err := roundTrip(col, val, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, sql.Row{int64(1), val}, r)
+	}
+
+	unsupported(cols[1], sql.Point{X: 1, Y: 2})
+	unsupported(cols[1], sql.Point{X: 1, Y: 2}, WithSpatialEncoding(SpatialWKT))
+	unsupported(cols[2], uint64(1), WithBitEncoding(BitHexString))
+	unsupported(cols[3], "paris", WithNestedColumnPaths("."))
+
+	// BIT(1) booleans convert back to their bits, and the default forms of the other values are read back
+	supported(cols[2], uint64(1), WithBitEncoding(BitBoolean))
+	supported(cols[2], uint64(0), WithBitEncoding(BitBoolean))
+	supported(cols[2], uint64(1))
+	supported(cols[3], "paris")
+}
+
+func TestReaderRowsKey(t *testing.T) {
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	))
+	require.NoError(t, err)
+
+	read := func(doc string, opts ...ReaderOption) ([]sql.Row, error) {
+		rd, err := NewJSONReader(types.NewMemoryValueStore(), io.NopCloser(strings.NewReader(doc)), sch, opts...)
+		require.NoError(t, err)
+		var rows []sql.Row
+		for {
+			r, err := rd.ReadSqlRow(context.Background())
+			if err == io.EOF {
+				return rows, nil
+			} else if err != nil {
+				return rows, err
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	rows, err := read(`{"meta": {"rows": [{"id": 9}]}, "events": [{"id": 1}, {"id": 2}], "rows": [{"id": 3}]}`, WithRowsKey("events"))
+	require.NoError(t, err)
+	assert.Equal(t, []sql.Row{{int64(1)}, {int64(2)}}, rows)
+
+	rows, err = read(`{"rows": []}`)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	_, err = read(`{"events": [{"id": 1}]}`)
+	assert.Error(t, err)
+	_, err = read(`[{"id": 1}]`)
+	assert.Error(t, err)
+	_, err = read(`{"rows": [5]}`)
+	assert.Error(t, err)
+}
+
+func TestNDJSONReader(t *testing.T) {
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	))
+	require.NoError(t, err)
+
+	read := func(doc string) ([]sql.Row, error) {
+		rd, err := NewNDJSONReader(types.NewMemoryValueStore(), io.NopCloser(strings.NewReader(doc)), sch)
+		require.NoError(t, err)
+		var rows []sql.Row
+		for {
+			r, err := rd.ReadSqlRow(context.Background())
+			if err == io.EOF {
+				return rows, nil
+			} else if err != nil {
+				return rows, err
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	// the batch markers of a writer are skipped
+	rows := []sql.Row{{int64(1), "ann"}, {int64(2), nil}, {int64(3), "bob"}}
+	var buf bytes.Buffer
+	wr, err := NewNDJSONWriter(iohelp.NopWrCloser(&buf), sch, WithBatchMarkers(true), WithFlushEvery(2))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, wr.Close(context.Background()))
+	require.Contains(t, buf.String(), `{"_batch_end":true}`)
+
+	read1, err := read(buf.String())
+	require.NoError(t, err)
+	assert.Equal(t, rows, read1)
+
+	read2, err := read("{\"id\": 1}\n{\"_batch_end\": true}\n\n{\"id\": 2, \"name\": \"x\"}\n{\"_batch_end\": true}\n")
+	require.NoError(t, err)
+	assert.Equal(t, []sql.Row{{int64(1), nil}, {int64(2), "x"}}, read2)
+
+	read3, err := read("")
+	require.NoError(t, err)
+	assert.Empty(t, read3)
+
+	_, err = read("{\"id\": 1}\n[2]\n")
 	assert.Error(t, err)
 }
 
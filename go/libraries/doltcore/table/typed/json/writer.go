@@ -16,13 +16,26 @@ package json
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/expression/function"
 	"github.com/dolthub/vitess/go/sqltypes"
+	"golang.org/x/time/rate"
 
 	"github.com/dolthub/dolt/go/libraries/doltcore/row"
 	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
@@ -35,201 +48,1106 @@ import (
 const jsonHeader = `{"rows": [`
 const jsonFooter = `]}`
 
+// batchEndMarker is written at each flush boundary of line delimited output when batch markers are enabled
+const batchEndMarker = `{"_batch_end":true}`
+
+// seqField is the name of the field holding a row's global sequence number
+const seqField = "_seq"
+
+// typeField is the name of the field discriminating the schemas of rows written with WriteRowWithSchema
+const typeField = "_type"
+
+// rowCountPrefix and rowCountSuffix surround a fixed width placeholder for the row count when it's written at the top
+// of the document. The placeholder is padded with spaces, which are insignificant in JSON, and is wide enough for any
+// int64.
+const rowCountPrefix = `{"row_count": `
+const rowCountSuffix = `, "rows": [`
+const rowCountWidth = 19
+
+// rowCountKey is the key of the row count, whether it's written at the top of the document or in the footer
+const rowCountKey = "row_count"
+
+// WriteBufSize is the size of the write buffer of writers not configured with |WithBufferSize|
 var WriteBufSize = 256 * 1024
 var defaultString = sql.MustCreateStringWithDefaults(sqltypes.VarChar, 16383)
 
+var errWriteClosed = errors.New("write on closed json writer")
+
+// zeroDatetime is how the MySQL zero date 0000-00-00 00:00:00 is held, the closest Go can get to it
+var zeroDatetime = time.Unix(-62167219200, 0).UTC()
+
+const zeroDateStr = "0000-00-00"
+const zeroDatetimeStr = "0000-00-00 00:00:00"
+
+const microsPerDay = 24 * 60 * 60 * 1000000
+
+// RowWriter writes rows as JSON. Its output is deterministic: the same schema, options and sequence of rows always
+// produce the same bytes, as every object is written with its fields in a fixed order, whether it's a row, a nested
+// object of a JSON column, or a field of the envelope.
 type RowWriter struct {
 	closer      io.Closer
+	closed      bool
 	header      string
 	footer      string
 	separator   string
 	bWr         *bufio.Writer
+	out         *countingWriter
+	bufSize     int
+	flushEvery  int
 	sch         schema.Schema
 	rowsWritten int
+	// elemsWritten counts everything written between the header and footer: rows, and markers such as schema changes
+	elemsWritten int
+	sqlReplay    bool
+
+	checksumAlgo ChecksumAlgorithm
+	docHash      hash.Hash
+
+	coalesces []coalesce
+
+	limiter *rate.Limiter
+	limWr   *limitedWriter
+
+	gzipped   bool
+	gzipLevel int
+	gzWr      *gzip.Writer
+
+	// timeFracDigits is the number of fractional second digits written for TIME values, or -1 to use the precision
+	// declared by the column type
+	timeFracDigits int
+
+	// datetimeLayout is the Go time layout of datetime and time values, or "" to write them as MySQL does
+	datetimeLayout string
+	datetimeLoc    *time.Location
+	// datetimeFracDigits is the number of fractional second digits the datetime layout writes
+	datetimeFracDigits int
+
+	leadingRowCount bool
+	countSeeker     io.WriteSeeker
+	countOffset     int64
+
+	footerRowCount bool
+	footerMetadata func() map[string]interface{}
+
+	lossinessWarnings bool
+	warnings          []lossinessWarning
+
+	profiles []*columnProfile
+
+	keyNameFunc        func(name string) string
+	nestedKeyTransform bool
+
+	// pathDelim splits column names into the path of the nested object their values are written in, or is "" to write
+	// every column as a top-level field
+	pathDelim string
+
+	globalSeq      bool
+	globalSeqStart int64
+
+	surrogateIDField string
+
+	decimalVerbose    bool
+	decimalsAsNumbers bool
+	setsAsArrays      bool
+	enumsAsIndexes    bool
+	binaryEncoding    BinaryEncoding
+	spatialEncoding   SpatialEncoding
+	bitEncoding       BitEncoding
+
+	nonFinitePolicy NonFiniteFloatPolicy
+
+	normalizeJSON bool
+
+	typeTagAmbiguous bool
+
+	skipEnvelopeValidation bool
+
+	// tableName wraps each row written in an object naming the table it's from, or is "" to write rows unwrapped
+	tableName string
+
+	// projection is the names of the columns written, in the order written, or nil to write every column
+	projection []string
+	projected  map[string]struct{}
+
+	// multiSchema is set once a row has been written with its own schema
+	multiSchema bool
+
+	// lineEnding separates the objects of line delimited output
+	lineEnding LineEnding
+	// trailingLineEnding terminates the last object of line delimited output with a line ending as well
+	trailingLineEnding bool
+	// indent is the indent of each level of indented output, or "" for compact output
+	indent string
+
+	nullStringsAsEmpty bool
+	includeNulls       bool
+
+	allStrings     bool
+	allStringsNull NullRepresentation
+	nullToken      string
+
+	omitField func(col schema.Column, val interface{}) bool
+
+	hashChain bool
+	// prevHash is the hash of the last row written, or the chain's genesis value before the first row
+	prevHash string
+	genesis  string
+
+	// bucketCount is the number of hash buckets rows are assigned to, or 0 when bucketing is disabled. Bucketed rows are
+	// held in memory until Close.
+	bucketCount int
+	buckets     []bytes.Buffer
+
+	// marshalRow encodes the column values of a row, as JSON unless the writer was created for another encoding
+	marshalRow func(colValMap interface{}) ([]byte, error)
+	escapeHTML bool
+	// fieldOrders caches the order of the fields of rows of each schema written
+	fieldOrders map[schema.Schema][]string
+	sortFields  bool
+
+	batchMarkers bool
+	// batchStart is the value of rowsWritten when the current batch began
+	batchStart int
+}
+
+// lossinessWarning describes a value whose output representation lost fidelity
+type lossinessWarning struct {
+	Row    int    `json:"row"`
+	Column string `json:"column"`
+	Reason string `json:"reason"`
 }
 
 var _ table.SqlRowWriter = (*RowWriter)(nil)
 
 // NewJSONWriter returns a new writer that encodes rows as a single JSON object with a single key: "rows", which is a
-// slice of all rows. To customize the output of the JSON object emitted, use |NewJSONWriterWithHeader|
-func NewJSONWriter(wr io.WriteCloser, outSch schema.Schema) (*RowWriter, error) {
-	return NewJSONWriterWithHeader(wr, outSch, jsonHeader, jsonFooter, ",")
+// slice of all rows. The fields of each row are written in the schema's column order. To customize the output of the
+// JSON object emitted, use |NewJSONWriterWithHeader|
+func NewJSONWriter(wr io.WriteCloser, outSch schema.Schema, opts ...WriterOption) (*RowWriter, error) {
+	return NewJSONWriterWithHeader(wr, outSch, jsonHeader, jsonFooter, ",", opts...)
+}
+
+// NewJSONWriterWithKey returns a new writer like |NewJSONWriter| whose JSON object holds the slice of all rows under
+// |key| rather than "rows". The key is escaped as needed, and must be non-empty valid UTF-8.
+func NewJSONWriterWithKey(wr io.WriteCloser, outSch schema.Schema, key string, opts ...WriterOption) (*RowWriter, error) {
+	if key == "" {
+		return nil, errors.New("json rows key must not be empty")
+	}
+	if !utf8.ValidString(key) {
+		return nil, fmt.Errorf("json rows key %q is not valid UTF-8", key)
+	}
+
+	encKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONWriterWithHeader(wr, outSch, "{"+string(encKey)+": [", jsonFooter, ",", opts...)
 }
 
-func NewJSONWriterWithHeader(wr io.WriteCloser, outSch schema.Schema, header, footer, separator string) (*RowWriter, error) {
-	bwr := bufio.NewWriterSize(wr, WriteBufSize)
-	return &RowWriter{
-		closer:    wr,
-		bWr:       bwr,
-		sch:       outSch,
-		header:    header,
-		footer:    footer,
-		separator: separator,
-	}, nil
+// NewNDJSONWriter returns a new writer that encodes rows as newline delimited JSON: each row is an object on its own
+// line, with no enclosing object or array, and the last row is followed by a newline as well. A writer that writes no
+// rows writes nothing.
+func NewNDJSONWriter(wr io.WriteCloser, outSch schema.Schema, opts ...WriterOption) (*RowWriter, error) {
+	opts = append([]WriterOption{withTrailingLineEnding()}, opts...)
+	return NewJSONWriterWithHeader(wr, outSch, "", "", string(LF), opts...)
 }
 
-func (j *RowWriter) GetSchema() schema.Schema {
-	return j.sch
+// NewGzipJSONWriter returns a new writer like |NewJSONWriter| that compresses the document it writes with gzip, at
+// the compression |level| given, e.g. gzip.DefaultCompression. Rows are compressed as they're written, and the gzip
+// trailer is written by Close. A document checksum is of the uncompressed document, while a rate limit applies to the
+// compressed bytes written to |wr|.
+func NewGzipJSONWriter(wr io.WriteCloser, outSch schema.Schema, level int, opts ...WriterOption) (*RowWriter, error) {
+	opts = append([]WriterOption{withGzip(level)}, opts...)
+	return NewJSONWriter(wr, outSch, opts...)
 }
 
-// WriteRow encodes the row given into JSON format and writes it, returning any error
-func (j *RowWriter) WriteRow(ctx context.Context, r row.Row) error {
-	if j.rowsWritten == 0 {
-		err := iohelp.WriteAll(j.bWr, []byte(j.header))
-		if err != nil {
-			return err
+func withGzip(level int) WriterOption {
+	return func(j *RowWriter) error {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("invalid gzip compression level %d", level)
 		}
+		j.gzipped = true
+		j.gzipLevel = level
+		return nil
 	}
+}
 
-	allCols := j.sch.GetAllCols()
-	colValMap := make(map[string]interface{}, allCols.Size())
-	if err := allCols.Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
-		val, ok := r.GetColVal(tag)
-		if !ok || types.IsNull(val) {
-			return false, nil
+// withSortedFields writes the fields of each row in sorted order rather than schema order
+func withSortedFields() WriterOption {
+	return func(j *RowWriter) error {
+		j.sortFields = true
+		return nil
+	}
+}
+
+func withTrailingLineEnding() WriterOption {
+	return func(j *RowWriter) error {
+		j.trailingLineEnding = true
+		return nil
+	}
+}
+
+// JSONWriterOptions are the options of a writer created with |NewJSONWriterWithOptions|
+type JSONWriterOptions struct {
+	// IncludeNulls writes NULL column values as JSON null, so that every row has a field for every column, rather than
+	// omitting them
+	IncludeNulls bool
+}
+
+// NewJSONWriterWithOptions returns a new writer like |NewJSONWriter| configured by |jsonOpts|
+func NewJSONWriterWithOptions(wr io.WriteCloser, outSch schema.Schema, jsonOpts JSONWriterOptions, opts ...WriterOption) (*RowWriter, error) {
+	opts = append([]WriterOption{WithIncludeNulls(jsonOpts.IncludeNulls)}, opts...)
+	return NewJSONWriter(wr, outSch, opts...)
+}
+
+// NewJSONWriterWithHeader returns a new writer that writes |header|, then the rows given separated by |separator|, and
+// then |footer|. Unless the output is line delimited, the envelope must make a valid JSON document with any number of
+// rows, so that a malformed header or footer is reported here rather than by a consumer of the output. Use
+// |WithEnvelopeValidation| to write JSON fragments.
+func NewJSONWriterWithHeader(wr io.WriteCloser, outSch schema.Schema, header, footer, separator string, opts ...WriterOption) (*RowWriter, error) {
+	j := &RowWriter{
+		closer:         wr,
+		sch:            outSch,
+		header:         header,
+		footer:         footer,
+		separator:      separator,
+		timeFracDigits: -1,
+		lineEnding:     LF,
+		escapeHTML:     true,
+		bufSize:        WriteBufSize,
+		binaryEncoding: BinaryRaw,
+		prevHash:       DefaultHashChainGenesis,
+	}
+
+	for _, opt := range opts {
+		if err := opt(j); err != nil {
+			return nil, err
 		}
+	}
 
-		switch col.TypeInfo.GetTypeIdentifier() {
-		case typeinfo.DatetimeTypeIdentifier,
-			typeinfo.DecimalTypeIdentifier,
-			typeinfo.EnumTypeIdentifier,
-			typeinfo.InlineBlobTypeIdentifier,
-			typeinfo.SetTypeIdentifier,
-			typeinfo.TimeTypeIdentifier,
-			typeinfo.TupleTypeIdentifier,
-			typeinfo.UuidTypeIdentifier,
-			typeinfo.VarBinaryTypeIdentifier,
-			typeinfo.YearTypeIdentifier:
-			v, err := col.TypeInfo.FormatValue(val)
-			if err != nil {
-				return true, err
-			}
-			val = types.String(*v)
+	if err := j.validateSchema(); err != nil {
+		return nil, err
+	}
 
-		case typeinfo.BitTypeIdentifier,
-			typeinfo.BoolTypeIdentifier,
-			typeinfo.VarStringTypeIdentifier,
-			typeinfo.UintTypeIdentifier,
-			typeinfo.IntTypeIdentifier,
-			typeinfo.FloatTypeIdentifier:
-			// use primitive type
+	if j.marshalRow == nil {
+		j.marshalRow = j.marshalJSON
+	} else if j.indent != "" {
+		return nil, errors.New("indented output is only supported for json")
+	}
+
+	if j.footer != jsonFooter && j.hasFooterFields() {
+		return nil, errors.New("footer fields require the default json envelope")
+	}
+	if j.leadingRowCount {
+		if j.header != jsonHeader {
+			return nil, errors.New("leading row count requires the default json envelope")
+		}
+		if j.footerRowCount {
+			return nil, errors.New("the row count can't be written both at the top of the document and in its footer")
+		}
+		if j.checksumAlgo != "" {
+			return nil, errors.New("leading row count can't be combined with a document checksum")
+		}
+		if j.gzipped {
+			return nil, errors.New("leading row count can't be combined with compression")
+		}
+	}
+
+	if j.bucketCount > 0 {
+		if j.header != jsonHeader || j.footer != jsonFooter {
+			return nil, errors.New("hash bucketing requires the default json envelope")
+		}
+		if j.leadingRowCount || j.hasFooterFields() {
+			return nil, errors.New("hash bucketing can't be combined with envelope fields")
 		}
+		if j.hashChain {
+			return nil, errors.New("hash bucketing can't be combined with a hash chain")
+		}
+		j.buckets = make([]bytes.Buffer, j.bucketCount)
+	}
 
-		colValMap[col.Name] = val
+	if j.tableName != "" && j.hashChain {
+		// the chain is verified from the fields of each row at the top level of the document
+		return nil, errors.New("table wrapping can't be combined with a hash chain")
+	}
 
-		return false, nil
-	}); err != nil {
-		return err
+	if j.isLineDelimited() {
+		j.separator = string(j.lineEnding)
+	} else if j.batchMarkers {
+		return nil, errors.New("batch markers are only supported for line delimited json output")
 	}
 
-	data, err := marshalToJson(colValMap)
-	if err != nil {
-		return errors.New("marshaling did not work")
+	if j.indent != "" {
+		if j.isLineDelimited() {
+			return nil, errors.New("indented output can't be line delimited")
+		}
+		if j.bucketCount > 0 {
+			return nil, errors.New("indented output can't be combined with hash bucketing")
+		}
+		// each element starts on its own line, so any whitespace of the separator is redundant
+		j.separator = strings.TrimRight(j.separator, " \t\r\n")
 	}
 
-	if j.rowsWritten != 0 {
-		_, err := j.bWr.WriteString(j.separator)
-		if err != nil {
-			return err
+	if !j.skipEnvelopeValidation && !j.isLineDelimited() {
+		if err := validateEnvelope(j.header, j.footer, j.separator); err != nil {
+			return nil, err
 		}
 	}
 
-	newErr := iohelp.WriteAll(j.bWr, data)
-	if newErr != nil {
-		return newErr
+	j.genesis = j.prevHash
+	if err := j.bind(wr); err != nil {
+		return nil, err
 	}
-	j.rowsWritten++
+	return j, nil
+}
 
+// validateEnvelope checks that a header, footer and separator make a valid JSON document with no rows, one row, and
+// more than one row
+func validateEnvelope(header, footer, separator string) error {
+	const placeholder = "{}"
+	docs := []string{
+		header + footer,
+		header + placeholder + footer,
+		header + placeholder + separator + placeholder + footer,
+	}
+	for _, doc := range docs {
+		if !json.Valid([]byte(doc)) {
+			return fmt.Errorf("json header %q, footer %q and separator %q don't make a valid json document, e.g. %s", header, footer, separator, doc)
+		}
+	}
 	return nil
 }
 
-func (j *RowWriter) WriteSqlRow(ctx context.Context, row sql.Row) error {
-	if j.rowsWritten == 0 {
-		err := iohelp.WriteAll(j.bWr, []byte(j.header))
-		if err != nil {
-			return err
+// bind directs the writer's output to |wr|, building the chain of writers between its buffer and |wr| for a new
+// document. The buffer is reused if the writer has one.
+func (j *RowWriter) bind(wr io.WriteCloser) error {
+	j.closer = wr
+
+	var dest io.Writer = wr
+	if j.limiter != nil {
+		j.limWr = newLimitedWriter(wr, j.limiter)
+		dest = j.limWr
+	}
+	if j.gzipped {
+		if j.gzWr == nil {
+			var err error
+			j.gzWr, err = gzip.NewWriterLevel(dest, j.gzipLevel)
+			if err != nil {
+				return err
+			}
+		} else {
+			j.gzWr.Reset(dest)
+		}
+		dest = j.gzWr
+	}
+	if j.checksumAlgo != "" {
+		j.docHash = j.checksumAlgo.newHash()
+		dest = io.MultiWriter(dest, j.docHash)
+	}
+
+	if j.leadingRowCount {
+		j.header = jsonHeader
+		j.countSeeker = nil
+		// the count is only written for destinations that can actually seek, which rules out pipes and the like
+		if ws, ok := wr.(io.WriteSeeker); ok {
+			if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+				j.countSeeker = ws
+				j.countOffset = start + int64(len(rowCountPrefix))
+				j.header = rowCountPrefix + strings.Repeat(" ", rowCountWidth) + rowCountSuffix
+			}
 		}
 	}
 
+	if j.bWr == nil {
+		j.bWr = bufio.NewWriterSize(dest, j.bufSize)
+		j.out = &countingWriter{wr: j.bWr}
+	} else {
+		j.bWr.Reset(dest)
+		j.out.n = 0
+	}
+	return nil
+}
+
+// Reset rebinds a closed writer to a new destination and schema, so that it writes another document with the same
+// options, reusing its buffers. It's an error to reset a writer that hasn't been closed, as the document it was writing
+// would be left without its footer.
+func (j *RowWriter) Reset(wr io.WriteCloser, outSch schema.Schema) error {
+	if !j.closed {
+		return errors.New("json writer must be closed before it's reset")
+	}
+
+	prevSch := j.sch
+	j.sch = outSch
+	if err := j.validateSchema(); err != nil {
+		j.sch = prevSch
+		return err
+	}
+
+	j.closed = false
+	j.rowsWritten = 0
+	j.elemsWritten = 0
+	j.batchStart = 0
+	j.multiSchema = false
+	j.warnings = nil
+	j.prevHash = j.genesis
+	for i, p := range j.profiles {
+		j.profiles[i] = newColumnProfile(p.col)
+	}
+	for i := range j.buckets {
+		j.buckets[i].Reset()
+	}
+
+	return j.bind(wr)
+}
+
+// validateColumnPaths reports columns of the schema given whose nested paths conflict up front, rather than on the
+// first row holding values for both
+func (j *RowWriter) validateColumnPaths(sch schema.Schema) error {
+	if j.pathDelim == "" || sch == nil {
+		return nil
+	}
+
+	keys := j.fieldOrder(sch)
+	fields := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		fields[key] = nil
+	}
+	_, _, err := j.nestColVals(keys, fields)
+	return err
+}
+
+func (j *RowWriter) GetSchema() schema.Schema {
+	return j.sch
+}
+
+// BytesWritten returns the number of bytes of the document written so far, including the footer once the writer is
+// closed. Bytes are counted as they're written to the writer's buffer, so the count includes bytes not yet flushed to
+// the destination. The bytes of a compressed document are counted before compression.
+func (j *RowWriter) BytesWritten() int64 {
+	return j.out.n
+}
+
+// WriteRow encodes the row given into JSON format and writes it, returning any error. Nothing is written if |ctx| is
+// done, and its error is returned.
+func (j *RowWriter) WriteRow(ctx context.Context, r row.Row) error {
+	if j.closed {
+		return errWriteClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.setCtx(ctx)
+	defer j.clearCtx()
+
+	if j.multiSchema {
+		return errors.New("rows of a writer with multiple schemas must be written with WriteRowWithSchema")
+	}
+
 	allCols := j.sch.GetAllCols()
 	colValMap := make(map[string]interface{}, allCols.Size())
 	if err := allCols.Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
-		val := row[allCols.TagToIdx[tag]]
-		if val == nil {
+		if !j.projects(col.Name) {
+			return false, nil
+		}
+
+		val, ok := r.GetColVal(tag)
+		if !ok || types.IsNull(val) {
+			j.addNullColVal(colValMap, col)
 			return false, nil
 		}
 
 		switch col.TypeInfo.GetTypeIdentifier() {
-		case typeinfo.DatetimeTypeIdentifier,
-			typeinfo.DecimalTypeIdentifier,
-			typeinfo.EnumTypeIdentifier,
-			typeinfo.InlineBlobTypeIdentifier,
-			typeinfo.SetTypeIdentifier,
-			typeinfo.TimeTypeIdentifier,
-			typeinfo.TupleTypeIdentifier,
-			typeinfo.UuidTypeIdentifier,
+		case typeinfo.EnumTypeIdentifier,
+			typeinfo.SetTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ConvertNomsValueToValue(val)
+			if err != nil {
+				return true, err
+			}
+			if structured, ok, err := j.structuredEnumSetValue(col, sqlVal); err != nil {
+				return true, err
+			} else if ok {
+				j.addColVal(colValMap, col, structured)
+				return false, nil
+			}
+			v, err := col.TypeInfo.FormatValue(val)
+			if err != nil {
+				return true, err
+			}
+			val = types.String(*v)
+
+		case typeinfo.InlineBlobTypeIdentifier,
 			typeinfo.VarBinaryTypeIdentifier:
-			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			v, err := col.TypeInfo.FormatValue(val)
 			if err != nil {
 				return true, err
 			}
-			val = sqlVal.ToString()
+			val = types.String(j.binaryString(col, *v))
+
+		case typeinfo.GeometryTypeIdentifier,
+			typeinfo.PointTypeIdentifier,
+			typeinfo.LineStringTypeIdentifier,
+			typeinfo.PolygonTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ConvertNomsValueToValue(val)
+			if err != nil {
+				return true, err
+			}
+			spatial, err := j.spatialValue(sqlVal)
+			if err != nil {
+				return true, err
+			}
+			j.addColVal(colValMap, col, spatial)
+			return false, nil
+
+		case typeinfo.DatetimeTypeIdentifier:
+			if j.datetimeLayout != "" {
+				val = types.String(j.formatDatetime(col, time.Time(val.(types.Timestamp))))
+				break
+			}
+			v, err := col.TypeInfo.FormatValue(val)
+			if err != nil {
+				return true, err
+			}
+			val = types.String(*v)
+
+		case typeinfo.TupleTypeIdentifier,
+			typeinfo.UuidTypeIdentifier:
+			v, err := col.TypeInfo.FormatValue(val)
+			if err != nil {
+				return true, err
+			}
+			val = types.String(*v)
+
+		case typeinfo.DecimalTypeIdentifier:
+			v, err := col.TypeInfo.FormatValue(val)
+			if err != nil {
+				return true, err
+			}
+			if j.decimalVerbose {
+				j.addColVal(colValMap, col, newVerboseDecimal(col, *v))
+				return false, nil
+			} else if j.decimalsAsNumbers {
+				j.addColVal(colValMap, col, json.Number(*v))
+				return false, nil
+			}
+			val = types.String(*v)
+
+		case typeinfo.TimeTypeIdentifier:
+			val = types.String(j.formatTime(col, sql.Timespan(val.(types.Int))))
 
-		case typeinfo.BitTypeIdentifier,
-			typeinfo.BoolTypeIdentifier,
-			typeinfo.VarStringTypeIdentifier,
+		case typeinfo.JSONTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ConvertNomsValueToValue(val)
+			if err != nil {
+				return true, err
+			}
+			nested, err := j.jsonColumnValue(col, sqlVal)
+			if err != nil {
+				return true, err
+			}
+			j.addColVal(colValMap, col, nested)
+			return false, nil
+
+		case typeinfo.BoolTypeIdentifier:
+			if j.sqlReplay {
+				val = types.Uint(numericBool(bool(val.(types.Bool))))
+			}
+
+		case typeinfo.BitTypeIdentifier:
+			if structured, ok, err := j.structuredBitValue(col, uint64(val.(types.Uint))); err != nil {
+				return true, err
+			} else if ok {
+				j.addColVal(colValMap, col, structured)
+				return false, nil
+			}
+
+		case typeinfo.FloatTypeIdentifier:
+			if f := float64(val.(types.Float)); math.IsNaN(f) || math.IsInf(f, 0) {
+				v, err := j.nonFiniteFloat(col, f)
+				if err != nil {
+					return true, err
+				}
+				j.addColVal(colValMap, col, v)
+				return false, nil
+			}
+
+		case typeinfo.VarStringTypeIdentifier,
 			typeinfo.UintTypeIdentifier,
 			typeinfo.IntTypeIdentifier,
-			typeinfo.FloatTypeIdentifier,
 			typeinfo.YearTypeIdentifier:
-			// use primitive type
+			// use primitive type. YEAR is written as a number, as MySQL treats it
 		}
 
-		colValMap[col.Name] = val
+		j.addColVal(colValMap, col, val)
 
 		return false, nil
 	}); err != nil {
 		return err
 	}
 
-	data, err := marshalToJson(colValMap)
+	return j.writeColVals(j.sch, "", colValMap)
+}
+
+func (j *RowWriter) WriteSqlRow(ctx context.Context, row sql.Row) error {
+	if j.closed {
+		return errWriteClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.setCtx(ctx)
+	defer j.clearCtx()
+
+	if j.multiSchema {
+		return errors.New("rows of a writer with multiple schemas must be written with WriteRowWithSchema")
+	}
+
+	colValMap, err := j.sqlRowColVals(j.sch, row)
 	if err != nil {
-		return errors.New("marshaling did not work")
+		return err
 	}
 
-	if j.rowsWritten != 0 {
-		_, err := j.bWr.WriteString(j.separator)
-		if err != nil {
+	return j.writeColVals(j.sch, "", colValMap)
+}
+
+// WriteSqlRows writes each of the rows given, with output identical to that of calling WriteSqlRow for each in turn.
+// It saves the per row overhead of doing so by reusing one map of column values for every row. Rows are written until
+// one fails or |ctx| is done, and the error is returned.
+func (j *RowWriter) WriteSqlRows(ctx context.Context, rows []sql.Row) error {
+	if j.closed {
+		return errWriteClosed
+	}
+	j.setCtx(ctx)
+	defer j.clearCtx()
+
+	if j.multiSchema {
+		return errors.New("rows of a writer with multiple schemas must be written with WriteRowWithSchema")
+	}
+
+	colValMap := make(map[string]interface{}, j.sch.GetAllCols().Size())
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-	}
 
-	newErr := iohelp.WriteAll(j.bWr, data)
-	if newErr != nil {
-		return newErr
+		for name := range colValMap {
+			delete(colValMap, name)
+		}
+		if err := j.fillSqlRowColVals(j.sch, row, colValMap); err != nil {
+			return err
+		}
+		if err := j.writeColVals(j.sch, "", colValMap); err != nil {
+			return err
+		}
 	}
-	j.rowsWritten++
 
 	return nil
 }
 
-func (j *RowWriter) Flush() error {
-	return j.bWr.Flush()
-}
+// WriteRowWithSchema writes a row of the schema given rather than the writer's schema, tagged with a "_type" field
+// holding |typeName|, so that rows of differently shaped result sets can be interleaved in one output, such as an
+// NDJSON stream. Once a writer has been given a row this way all of its rows must be written with WriteRowWithSchema.
+// Anything that depends on the writer's schema or envelope, i.e. coalescing, hash bucketing, and the leading row count,
+// checksum and warnings fields, doesn't apply to such a writer, and writing a row with its own schema is an error if
+// any of them is configured.
+func (j *RowWriter) WriteRowWithSchema(ctx context.Context, r sql.Row, sch schema.Schema, typeName string) error {
+	if j.closed {
+		return errWriteClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.setCtx(ctx)
+	defer j.clearCtx()
 
-// Close should flush all writes, release resources being held
-func (j *RowWriter) Close(ctx context.Context) error {
-	if j.closer != nil {
+	if !j.multiSchema {
 		if j.rowsWritten > 0 {
-			err := iohelp.WriteAll(j.bWr, []byte(j.footer))
-			if err != nil {
+			return errors.New("can't write rows with their own schemas after rows of the writer's schema")
+		}
+		if len(j.coalesces) > 0 || j.projection != nil || j.bucketCount > 0 || j.leadingRowCount || j.hasFooterFields() {
+			return errors.New("schema and envelope dependent options don't apply to rows written with their own schemas")
+		}
+		j.multiSchema = true
+	}
+
+	colValMap, err := j.sqlRowColVals(sch, r)
+	if err != nil {
+		return err
+	}
+
+	return j.writeColVals(sch, typeName, colValMap)
+}
+
+// sqlRowColVals converts the values of a row of the schema given to the values written for each column
+func (j *RowWriter) sqlRowColVals(sch schema.Schema, row sql.Row) (map[string]interface{}, error) {
+	colValMap := make(map[string]interface{}, sch.GetAllCols().Size())
+	if err := j.fillSqlRowColVals(sch, row, colValMap); err != nil {
+		return nil, err
+	}
+	return colValMap, nil
+}
+
+// fillSqlRowColVals adds the values written for each column of a row of the schema given to |colValMap|
+func (j *RowWriter) fillSqlRowColVals(sch schema.Schema, row sql.Row, colValMap map[string]interface{}) error {
+	allCols := sch.GetAllCols()
+	if err := allCols.Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+		if !j.projects(col.Name) {
+			return false, nil
+		}
+
+		val := row[allCols.TagToIdx[tag]]
+		if val == nil {
+			j.addNullColVal(colValMap, col)
+			return false, nil
+		}
+
+		switch col.TypeInfo.GetTypeIdentifier() {
+		case typeinfo.EnumTypeIdentifier,
+			typeinfo.SetTypeIdentifier:
+			structured, ok, err := j.structuredEnumSetValue(col, val)
+			if err != nil {
+				return true, err
+			}
+			if ok {
+				val = structured
+				break
+			}
+			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			if err != nil {
+				return true, err
+			}
+			val = sqlVal.ToString()
+
+		case typeinfo.InlineBlobTypeIdentifier,
+			typeinfo.VarBinaryTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			if err != nil {
+				return true, err
+			}
+			val = j.binaryString(col, sqlVal.ToString())
+
+		case typeinfo.GeometryTypeIdentifier,
+			typeinfo.PointTypeIdentifier,
+			typeinfo.LineStringTypeIdentifier,
+			typeinfo.PolygonTypeIdentifier:
+			val, err = j.spatialValue(val)
+			if err != nil {
+				return true, err
+			}
+
+		case typeinfo.DatetimeTypeIdentifier:
+			if j.datetimeLayout != "" {
+				t, err := col.TypeInfo.ToSqlType().Convert(val)
+				if err != nil {
+					return true, err
+				}
+				val = j.formatDatetime(col, t.(time.Time))
+				break
+			}
+			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			if err != nil {
+				return true, err
+			}
+			val = sqlVal.ToString()
+
+		case typeinfo.TupleTypeIdentifier,
+			typeinfo.UuidTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			if err != nil {
+				return true, err
+			}
+			val = sqlVal.ToString()
+
+		case typeinfo.DecimalTypeIdentifier:
+			sqlVal, err := col.TypeInfo.ToSqlType().SQL(nil, val)
+			if err != nil {
+				return true, err
+			}
+			if j.decimalVerbose {
+				val = newVerboseDecimal(col, sqlVal.ToString())
+			} else if j.decimalsAsNumbers {
+				val = json.Number(sqlVal.ToString())
+			} else {
+				val = sqlVal.ToString()
+			}
+
+		case typeinfo.TimeTypeIdentifier:
+			ts, err := sql.Time.ConvertToTimespan(val)
+			if err != nil {
+				return true, err
+			}
+			val = j.formatTime(col, ts)
+
+		case typeinfo.JSONTypeIdentifier:
+			val, err = j.jsonColumnValue(col, val)
+			if err != nil {
+				return true, err
+			}
+
+		case typeinfo.BoolTypeIdentifier:
+			if b, ok := val.(bool); ok && j.sqlReplay {
+				val = numericBool(b)
+			}
+
+		case typeinfo.BitTypeIdentifier:
+			structured, ok, err := j.structuredBitValue(col, val)
+			if err != nil {
+				return true, err
+			}
+			if ok {
+				val = structured
+			}
+
+		case typeinfo.FloatTypeIdentifier:
+			var f float64
+			switch v := val.(type) {
+			case float64:
+				f = v
+			case float32:
+				f = float64(v)
+			}
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				val, err = j.nonFiniteFloat(col, f)
+				if err != nil {
+					return true, err
+				}
+			}
+
+		case typeinfo.VarStringTypeIdentifier,
+			typeinfo.UintTypeIdentifier,
+			typeinfo.IntTypeIdentifier,
+			typeinfo.YearTypeIdentifier:
+			// use primitive type. YEAR is written as a number, as MySQL treats it
+		}
+
+		j.addColVal(colValMap, col, val)
+
+		return false, nil
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// projects returns whether the column named is written, which is every column unless the writer has a projection
+func (j *RowWriter) projects(name string) bool {
+	if j.projection == nil {
+		return true
+	}
+	_, ok := j.projected[name]
+	return ok
+}
+
+// validateSchema checks the options that depend on the columns of the writer's schema against it. It's run whenever
+// the writer is given a schema, by its constructor and by Reset.
+func (j *RowWriter) validateSchema() error {
+	if err := j.validateCoalesces(); err != nil {
+		return err
+	}
+
+	if len(j.profiles) > 0 {
+		allCols := j.sch.GetAllCols()
+		for _, p := range j.profiles {
+			if _, ok := allCols.GetByName(p.col); !ok {
+				return fmt.Errorf("profiled column '%s' not found in schema", p.col)
+			}
+		}
+	}
+
+	if j.bucketCount > 0 && schema.IsKeyless(j.sch) {
+		return errors.New("hash bucketing requires a primary key")
+	}
+
+	if j.surrogateIDField != "" {
+		if !schema.IsKeyless(j.sch) {
+			return errors.New("surrogate ids are only supported for keyless schemas")
+		}
+		if _, ok := j.sch.GetAllCols().GetByName(j.surrogateIDField); ok {
+			return fmt.Errorf("surrogate id field '%s' conflicts with an existing column", j.surrogateIDField)
+		}
+	}
+
+	if err := j.validateProjection(); err != nil {
+		return err
+	}
+	if err := j.validateColumnPaths(j.sch); err != nil {
+		return err
+	}
+	return j.validateBitEncoding(j.sch)
+}
+
+// validateCoalesces checks that each coalesce's sources are columns of the writer's schema with compatible types, and
+// that its target doesn't conflict with a column
+func (j *RowWriter) validateCoalesces() error {
+	if len(j.coalesces) == 0 {
+		return nil
+	}
+
+	allCols := j.sch.GetAllCols()
+	for _, c := range j.coalesces {
+		if _, ok := allCols.GetByName(c.target); ok && !(c.dropSources && containsStr(c.sources, c.target)) {
+			return fmt.Errorf("coalesce target '%s' conflicts with an existing column", c.target)
+		}
+
+		var class string
+		for _, name := range c.sources {
+			col, ok := allCols.GetByName(name)
+			if !ok {
+				return fmt.Errorf("coalesce source column '%s' not found in schema", name)
+			}
+
+			colClass := typeClass(col.TypeInfo.GetTypeIdentifier())
+			if class == "" {
+				class = colClass
+			} else if class != colClass {
+				return fmt.Errorf("coalesce source column '%s' of type %s is not compatible with the other sources of '%s'", name, col.TypeInfo.String(), c.target)
+			}
+		}
+	}
+	return nil
+}
+
+// validateProjection checks that the projected columns are all in the writer's schema, and that the columns other
+// options read are all written
+func (j *RowWriter) validateProjection() error {
+	if j.projection == nil {
+		return nil
+	}
+
+	allCols := j.sch.GetAllCols()
+	for _, name := range j.projection {
+		if _, ok := allCols.GetByName(name); !ok {
+			return fmt.Errorf("projected column '%s' not found in schema", name)
+		}
+	}
+	for _, c := range j.coalesces {
+		for _, name := range c.sources {
+			if !j.projects(name) {
+				return fmt.Errorf("coalesce source column '%s' is not in the column projection", name)
+			}
+		}
+	}
+	for _, p := range j.profiles {
+		if !j.projects(p.col) {
+			return fmt.Errorf("profiled column '%s' is not in the column projection", p.col)
+		}
+	}
+	if j.bucketCount > 0 {
+		return j.sch.GetPKCols().Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+			if !j.projects(col.Name) {
+				return true, fmt.Errorf("hash bucketing requires primary key column '%s' in the column projection", col.Name)
+			}
+			return false, nil
+		})
+	}
+	return nil
+}
+
+// setCtx records the context of the current call for writes to the destination that may block
+func (j *RowWriter) setCtx(ctx context.Context) {
+	if j.limWr != nil {
+		j.limWr.ctx = ctx
+	}
+}
+
+// clearCtx forgets the context of a call that's returning, so later writes don't wait on it
+func (j *RowWriter) clearCtx() {
+	if j.limWr != nil {
+		j.limWr.ctx = context.Background()
+	}
+}
+
+func (j *RowWriter) Flush() error {
+	if j.closed {
+		return errWriteClosed
+	}
+	err := j.writeBatchMarker()
+	if err != nil {
+		return err
+	}
+
+	err = j.bWr.Flush()
+	if err != nil {
+		return err
+	}
+
+	if j.gzWr != nil {
+		return j.gzWr.Flush()
+	}
+	return nil
+}
+
+// writeBatchMarker ends the current batch with a {"_batch_end": true} line when batch markers are enabled and rows have
+// been written since the last marker
+func (j *RowWriter) writeBatchMarker() error {
+	if !j.batchMarkers || j.rowsWritten == j.batchStart {
+		return nil
+	}
+
+	err := j.writeElement([]byte(batchEndMarker))
+	if err != nil {
+		return err
+	}
+	j.batchStart = j.rowsWritten
+
+	return nil
+}
+
+// Close should flush all writes, release resources being held
+func (j *RowWriter) Close(ctx context.Context) error {
+	j.setCtx(ctx)
+	defer j.clearCtx()
+	if j.closer != nil {
+		err := j.writeBatchMarker()
+		if err != nil {
+			return err
+		}
+
+		if j.bucketCount > 0 {
+			err := j.writeBuckets()
+			if err != nil {
+				return err
+			}
+		} else {
+			// a writer that never wrote a row still writes its envelope, so that the output is a valid document
+			if j.elemsWritten == 0 {
+				err := iohelp.WriteAll(j.out, []byte(j.header))
+				if err != nil {
+					return err
+				}
+			}
+
+			if j.trailingLineEnding && j.elemsWritten > 0 {
+				err := iohelp.WriteAll(j.out, []byte(j.lineEnding))
+				if err != nil {
+					return err
+				}
+			} else if j.indent != "" && j.elemsWritten > 0 {
+				// the footer closes the envelope on a line of its own
+				err := iohelp.WriteAll(j.out, []byte("\n"))
+				if err != nil {
+					return err
+				}
+			}
+
+			err := j.writeFooter()
+			if err != nil {
 				return err
 			}
 		}
 
 		errFl := j.bWr.Flush()
+		if j.gzWr != nil {
+			// the gzip trailer must be written before the destination is closed
+			if err := j.gzWr.Close(); err != nil && errFl == nil {
+				errFl = err
+			}
+		}
+		if errFl == nil && j.countSeeker != nil {
+			errFl = j.writeLeadingRowCount()
+		}
 		errCl := j.closer.Close()
 		j.closer = nil
+		j.closed = true
 
 		if errCl != nil {
 			return errCl
@@ -241,6 +1159,1074 @@ func (j *RowWriter) Close(ctx context.Context) error {
 	return errors.New("already closed")
 }
 
+// addColVal adds the value of a column to a row's values, unless the field omission predicate omits it
+func (j *RowWriter) addColVal(colValMap map[string]interface{}, col schema.Column, val interface{}) {
+	if j.omitField != nil && j.omitField(col, omitPredicateValue(col, val)) {
+		return
+	}
+	colValMap[col.Name] = val
+}
+
+// omitPredicateValue returns the value the field omission predicate is called with for |val|. The noms primitives of
+// rows written with WriteRow are converted to the Go values of their column type, as held by rows written with
+// WriteSqlRow, so that a predicate sees the same values whichever way a row is written.
+func omitPredicateValue(col schema.Column, val interface{}) interface{} {
+	nv, ok := val.(types.Value)
+	if !ok {
+		return val
+	}
+
+	if nv.Kind() == col.TypeInfo.NomsKind() {
+		if v, err := col.TypeInfo.ConvertNomsValueToValue(nv); err == nil {
+			return v
+		}
+	}
+
+	// a value converted for output, e.g. a DATETIME formatted as a string
+	switch v := nv.(type) {
+	case types.String:
+		return string(v)
+	case types.Int:
+		return int64(v)
+	case types.Uint:
+		return uint64(v)
+	case types.Float:
+		return float64(v)
+	case types.Bool:
+		return bool(v)
+	}
+	return val
+}
+
+// addNullColVal adds the representation of a NULL column value to a row's values, if it has one
+func (j *RowWriter) addNullColVal(colValMap map[string]interface{}, col schema.Column) {
+	if j.nullStringsAsEmpty && col.IsNullable() && isTextColumn(col) {
+		j.addColVal(colValMap, col, "")
+		return
+	}
+
+	if j.allStrings {
+		switch j.allStringsNull {
+		case NullAsJSONNull:
+			colValMap[col.Name] = nil
+			return
+		case NullAsToken:
+			colValMap[col.Name] = j.nullToken
+			return
+		}
+	}
+
+	if j.includeNulls {
+		colValMap[col.Name] = nil
+	}
+}
+
+// surrogateID returns the surrogate id of the row being written: its global sequence number if a global sequence is
+// configured, and otherwise its one based position in this export
+func (j *RowWriter) surrogateID() int64 {
+	if j.globalSeq {
+		return j.globalSeqStart + int64(j.rowsWritten)
+	}
+	return int64(j.rowsWritten) + 1
+}
+
+// writeColVals finishes, encodes, and writes the column values of a row of the schema given, tagging it with
+// |typeName| when it's non-empty
+func (j *RowWriter) writeColVals(sch schema.Schema, typeName string, colValMap map[string]interface{}) error {
+	if j.allStrings {
+		for name, val := range colValMap {
+			if val == nil {
+				// a NULL written as JSON null
+				continue
+			}
+			str, err := valueText(val)
+			if err != nil {
+				return err
+			}
+			colValMap[name] = str
+		}
+	}
+
+	if j.typeTagAmbiguous {
+		j.tagAmbiguousVals(sch, colValMap)
+	}
+
+	for _, p := range j.profiles {
+		if err := p.add(colValMap[p.col]); err != nil {
+			return err
+		}
+	}
+
+	bucket := -1
+	if j.bucketCount > 0 {
+		var err error
+		bucket, err = j.bucketOf(colValMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	colValMap, err := j.finishRow(colValMap)
+	if err != nil {
+		return err
+	}
+
+	if typeName != "" {
+		if _, ok := colValMap[typeField]; ok {
+			return fmt.Errorf("field '%s' conflicts with the type discriminator field", typeField)
+		}
+		colValMap[typeField] = typeName
+	}
+
+	if j.hashChain {
+		if _, ok := colValMap[prevHashField]; ok {
+			return fmt.Errorf("field '%s' conflicts with the hash chain field", prevHashField)
+		}
+		colValMap[prevHashField] = j.prevHash
+	}
+
+	keys := j.fieldOrder(sch)
+	if j.pathDelim != "" {
+		keys, colValMap, err = j.nestColVals(keys, colValMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	var rowVal interface{} = orderedRow{keys: keys, vals: colValMap, escapeHTML: j.escapeHTML}
+	if j.tableName != "" {
+		rowVal = tableRow{Table: j.tableName, Row: rowVal}
+	}
+
+	data, err := j.marshalRow(rowVal)
+	if err != nil {
+		// report the field that failed rather than the marshaler's wrapper of the error
+		var fieldErr *fieldEncodeError
+		if errors.As(err, &fieldErr) {
+			return fieldErr
+		}
+		return err
+	}
+
+	if j.indent != "" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, j.indent, j.indent); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	if j.hashChain {
+		j.prevHash = rowHash(data)
+	}
+
+	if bucket >= 0 {
+		bucketBuf := &j.buckets[bucket]
+		if bucketBuf.Len() > 0 {
+			bucketBuf.WriteString(j.separator)
+		}
+		bucketBuf.Write(data)
+	} else {
+		err = j.writeElement(data)
+		if err != nil {
+			return err
+		}
+	}
+	j.rowsWritten++
+
+	if j.flushEvery > 0 && j.rowsWritten%j.flushEvery == 0 {
+		return j.Flush()
+	}
+
+	return nil
+}
+
+// tagAmbiguousVals wraps the values of columns whose JSON representation is ambiguous in a type tag
+func (j *RowWriter) tagAmbiguousVals(sch schema.Schema, colValMap map[string]interface{}) {
+	_ = sch.GetAllCols().Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+		val, ok := colValMap[col.Name]
+		if !ok {
+			return false, nil
+		}
+		switch val.(type) {
+		case verboseDecimal, json.Number:
+			// a decimal that already carries its type, or is written as a number
+			return false, nil
+		}
+		if typeName := ambiguousTypeName(col); typeName != "" {
+			colValMap[col.Name] = typeTagged{Type: typeName, Value: val}
+		}
+		return false, nil
+	})
+}
+
+// ambiguousTypeName returns the type tag for columns written as strings that a consumer can't tell apart from plain
+// text, or "" for columns whose values are unambiguous
+func ambiguousTypeName(col schema.Column) string {
+	switch col.TypeInfo.GetTypeIdentifier() {
+	case typeinfo.DatetimeTypeIdentifier:
+		switch col.TypeInfo.ToSqlType().Type() {
+		case sqltypes.Date:
+			return "date"
+		case sqltypes.Timestamp:
+			return "timestamp"
+		default:
+			return "datetime"
+		}
+	case typeinfo.TimeTypeIdentifier:
+		return "time"
+	case typeinfo.DecimalTypeIdentifier:
+		return "decimal"
+	case typeinfo.InlineBlobTypeIdentifier, typeinfo.VarBinaryTypeIdentifier:
+		return "binary"
+	default:
+		return ""
+	}
+}
+
+// bucketOf returns the hash bucket of a row: the FNV-1a 64 bit hash of the JSON array of the row's primary key values,
+// in primary key order and encoded as they're written, modulo the number of buckets
+func (j *RowWriter) bucketOf(colValMap map[string]interface{}) (int, error) {
+	pkCols := j.sch.GetPKCols()
+	pkVals := make([]interface{}, 0, pkCols.Size())
+	_ = pkCols.Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+		pkVals = append(pkVals, colValMap[col.Name])
+		return false, nil
+	})
+
+	data, err := j.marshalJSON(pkVals)
+	if err != nil {
+		return 0, err
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return int(h.Sum64() % uint64(j.bucketCount)), nil
+}
+
+// writeBuckets writes the rows accumulated in each hash bucket as the document {"buckets": {"0": [...], ...}}
+func (j *RowWriter) writeBuckets() error {
+	err := iohelp.WriteAll(j.out, []byte(`{"buckets": {`))
+	if err != nil {
+		return err
+	}
+
+	for i := range j.buckets {
+		if i > 0 {
+			err = iohelp.WriteAll(j.out, []byte(","))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = iohelp.WriteAll(j.out, []byte(fmt.Sprintf(`"%d": [`, i)), j.buckets[i].Bytes(), []byte("]"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return iohelp.WriteAll(j.out, []byte("}}"))
+}
+
+// writeElement writes a row or marker to the output, preceded by the header if it's the first element written and by
+// the separator otherwise. Elements of indented output each start on a new line.
+func (j *RowWriter) writeElement(data []byte) error {
+	if j.elemsWritten == 0 {
+		err := iohelp.WriteAll(j.out, []byte(j.header))
+		if err != nil {
+			return err
+		}
+	} else {
+		err := iohelp.WriteAll(j.out, []byte(j.separator))
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.indent != "" {
+		err := iohelp.WriteAll(j.out, []byte("\n"+j.indent))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := iohelp.WriteAll(j.out, data)
+	if err != nil {
+		return err
+	}
+	j.elemsWritten++
+
+	return nil
+}
+
+// isLineDelimited returns whether the writer emits a bare stream of newline separated objects, with no enclosing
+// document
+func (j *RowWriter) isLineDelimited() bool {
+	return j.header == "" && j.footer == "" && (j.separator == string(LF) || j.separator == string(CRLF))
+}
+
+// UpdateSchema switches the writer to a new schema mid-stream, for long-running exports whose source schema can
+// change. Rows written after the call are encoded with |sch|, and a {"schema_change": {...}} marker describing the new
+// columns is written first so that a consumer knows how to interpret them. Updates are only supported for line
+// delimited output, since a marker in the middle of an enclosing "rows" array would be mistaken for a row. The call
+// must be made between writes, never concurrently with one. |sch| is checked against the writer's options as the
+// schema given at construction is, e.g. a writer with |WithSurrogateID| can't be updated to a keyed schema, and
+// |WithCoalesce| and |WithColumnProjection| can't be combined with schema updates at all.
+func (j *RowWriter) UpdateSchema(sch schema.Schema) error {
+	if j.closed {
+		return errWriteClosed
+	}
+	if !j.isLineDelimited() {
+		return errors.New("schema updates are only supported for line delimited json output")
+	}
+	if len(j.coalesces) > 0 {
+		return errors.New("schema updates can't be combined with coalesced fields")
+	}
+	if j.projection != nil {
+		return errors.New("schema updates can't be combined with a column projection")
+	}
+
+	prevSch := j.sch
+	j.sch = sch
+	if err := j.validateSchema(); err != nil {
+		j.sch = prevSch
+		return err
+	}
+
+	cols := make([]schemaChangeColumn, 0, sch.GetAllCols().Size())
+	_ = sch.GetAllCols().Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+		cols = append(cols, schemaChangeColumn{
+			Name:       col.Name,
+			Type:       col.TypeInfo.ToSqlType().String(),
+			PrimaryKey: col.IsPartOfPK,
+		})
+		return false, nil
+	})
+
+	data, err := j.marshalJSON(schemaChange{Change: schemaChangeDesc{Columns: cols}})
+	if err != nil {
+		j.sch = prevSch
+		return err
+	}
+
+	err = j.writeElement(data)
+	if err != nil {
+		j.sch = prevSch
+		return err
+	}
+
+	return nil
+}
+
+type schemaChange struct {
+	Change schemaChangeDesc `json:"schema_change"`
+}
+
+type schemaChangeDesc struct {
+	Columns []schemaChangeColumn `json:"columns"`
+}
+
+type schemaChangeColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PrimaryKey bool   `json:"primary_key"`
+}
+
+// finishRow applies the options that operate on a whole row to the column values of a row being written
+func (j *RowWriter) finishRow(colValMap map[string]interface{}) (map[string]interface{}, error) {
+	j.applyCoalesces(colValMap)
+
+	if j.keyNameFunc != nil {
+		var err error
+		colValMap, err = transformKeys(colValMap, j.keyNameFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if j.globalSeq {
+		if _, ok := colValMap[seqField]; ok {
+			return nil, fmt.Errorf("field '%s' conflicts with the global sequence field", seqField)
+		}
+		colValMap[seqField] = j.globalSeqStart + int64(j.rowsWritten)
+	}
+
+	if j.surrogateIDField != "" {
+		if _, ok := colValMap[j.surrogateIDField]; ok {
+			return nil, fmt.Errorf("field '%s' conflicts with the surrogate id field", j.surrogateIDField)
+		}
+		colValMap[j.surrogateIDField] = j.surrogateID()
+	}
+
+	return colValMap, nil
+}
+
+// nestColVals builds the nested objects of a row whose field names are paths split by the writer's path delimiter,
+// returning the order of the row's top-level fields. The fields of each object are in the order of the first field in
+// |order| under them, whether or not the row has a value for it, with any fields not in |order| following in sorted
+// order. A field whose path runs through the value of another field, such as "address.city" written along with a
+// scalar "address", is an error.
+func (j *RowWriter) nestColVals(order []string, colValMap map[string]interface{}) ([]string, map[string]interface{}, error) {
+	var names []string
+	for _, name := range order {
+		if _, ok := colValMap[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) < len(colValMap) {
+		var rest []string
+		for name := range colValMap {
+			if !containsStr(order, name) {
+				rest = append(rest, name)
+			}
+		}
+		sort.Strings(rest)
+		names = append(names, rest...)
+	}
+
+	// the order of the keys of each object, by the path of the object
+	keyOrders := make(map[string][]string)
+	for _, name := range order {
+		path := strings.Split(name, j.pathDelim)
+		for i, key := range path {
+			prefix := strings.Join(path[:i], j.pathDelim)
+			if !containsStr(keyOrders[prefix], key) {
+				keyOrders[prefix] = append(keyOrders[prefix], key)
+			}
+		}
+	}
+
+	root := &orderedRow{keys: keyOrders[""], vals: make(map[string]interface{}, len(colValMap)), escapeHTML: j.escapeHTML}
+	for _, name := range names {
+		path := strings.Split(name, j.pathDelim)
+		obj := root
+		for i, key := range path[:len(path)-1] {
+			val, ok := obj.vals[key]
+			if !ok {
+				child := &orderedRow{
+					keys:       keyOrders[strings.Join(path[:i+1], j.pathDelim)],
+					vals:       make(map[string]interface{}),
+					escapeHTML: j.escapeHTML,
+				}
+				obj.vals[key] = child
+				obj = child
+				continue
+			}
+			child, ok := val.(*orderedRow)
+			if !ok {
+				return nil, nil, fmt.Errorf("field '%s' conflicts with the value of field '%s'", name, strings.Join(path[:i+1], j.pathDelim))
+			}
+			obj = child
+		}
+
+		key := path[len(path)-1]
+		if _, ok := obj.vals[key]; ok {
+			return nil, nil, fmt.Errorf("field '%s' conflicts with the nested fields under it", name)
+		}
+		obj.vals[key] = colValMap[name]
+	}
+
+	return root.keys, root.vals, nil
+}
+
+// binaryString returns the string written for the bytes of a binary or blob value, encoded as configured
+func (j *RowWriter) binaryString(col schema.Column, raw string) string {
+	if j.binaryEncoding == BinaryBase64 {
+		return base64.StdEncoding.EncodeToString([]byte(raw))
+	}
+	if !utf8.ValidString(raw) {
+		j.warnLossy(col, "invalid UTF-8 replaced")
+	}
+	return raw
+}
+
+// spatialValue returns the value written for a geometry value: a GeoJSON geometry object, or a WKT string as
+// written by ST_AsWKT if the writer is configured to write them
+func (j *RowWriter) spatialValue(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case sql.Point:
+		if j.spatialEncoding == SpatialWKT {
+			return "POINT(" + function.PointToWKT(v, v.SRID == sql.GeoSpatialSRID) + ")", nil
+		}
+		return geoJSON{Type: "Point", Coordinates: function.PointToSlice(v)}, nil
+	case sql.LineString:
+		if j.spatialEncoding == SpatialWKT {
+			return "LINESTRING(" + function.LineToWKT(v, v.SRID == sql.GeoSpatialSRID) + ")", nil
+		}
+		return geoJSON{Type: "LineString", Coordinates: function.LineToSlice(v)}, nil
+	case sql.Polygon:
+		if j.spatialEncoding == SpatialWKT {
+			return "POLYGON(" + function.PolygonToWKT(v, v.SRID == sql.GeoSpatialSRID) + ")", nil
+		}
+		return geoJSON{Type: "Polygon", Coordinates: function.PolyToSlice(v)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected value of type %T for a geometry column", val)
+	}
+}
+
+// structuredBitValue returns the value written for a BIT column configured to be written as a boolean or a hex string.
+// It returns false if the column is written as an integer, which it always is for SQL replay.
+func (j *RowWriter) structuredBitValue(col schema.Column, val interface{}) (interface{}, bool, error) {
+	if j.sqlReplay || (j.bitEncoding != BitBoolean && j.bitEncoding != BitHexString) {
+		return nil, false, nil
+	}
+
+	bits, ok := val.(uint64)
+	if !ok {
+		converted, err := col.TypeInfo.ToSqlType().Convert(val)
+		if err != nil {
+			return nil, false, err
+		}
+		if bits, ok = converted.(uint64); !ok {
+			return nil, false, fmt.Errorf("unexpected value of type %T for BIT column '%s'", val, col.Name)
+		}
+	}
+
+	if j.bitEncoding == BitBoolean {
+		if err := checkBooleanBitColumn(col); err != nil {
+			return nil, false, err
+		}
+		return bits != 0, true, nil
+	}
+	return fmt.Sprintf("0x%X", bits), true, nil
+}
+
+// checkBooleanBitColumn returns an error if a BIT column is too wide to be written as a boolean
+func checkBooleanBitColumn(col schema.Column) error {
+	if bitType, ok := col.TypeInfo.ToSqlType().(sql.BitType); ok && bitType.NumberOfBits() > 1 {
+		return fmt.Errorf("column '%s' of type BIT(%d) can't be written as a boolean", col.Name, bitType.NumberOfBits())
+	}
+	return nil
+}
+
+// validateBitEncoding checks that the BIT columns of the schema given can be written with the writer's bit encoding
+func (j *RowWriter) validateBitEncoding(sch schema.Schema) error {
+	if j.bitEncoding != BitBoolean || sch == nil {
+		return nil
+	}
+	return sch.GetAllCols().Iter(func(tag uint64, col schema.Column) (stop bool, err error) {
+		if col.TypeInfo.GetTypeIdentifier() != typeinfo.BitTypeIdentifier || !j.projects(col.Name) {
+			return false, nil
+		}
+		if err := checkBooleanBitColumn(col); err != nil {
+			return true, err
+		}
+		return false, nil
+	})
+}
+
+// structuredEnumSetValue returns the value written for an ENUM or SET column configured to be written in structured
+// form: the index of an ENUM value, or the members of a SET value as an array in the order of the column definition.
+// It returns false if the column is written as its string label.
+func (j *RowWriter) structuredEnumSetValue(col schema.Column, val interface{}) (interface{}, bool, error) {
+	switch sqlType := col.TypeInfo.ToSqlType().(type) {
+	case sql.EnumType:
+		if !j.enumsAsIndexes {
+			return nil, false, nil
+		}
+		if idx, ok := val.(uint16); ok {
+			return idx, true, nil
+		}
+		idx, err := sqlType.Convert(val)
+		if err != nil {
+			return nil, false, err
+		}
+		return idx, true, nil
+
+	case sql.SetType:
+		if !j.setsAsArrays {
+			return nil, false, nil
+		}
+		converted, err := sqlType.Convert(val)
+		if err != nil {
+			return nil, false, err
+		}
+		bitField := converted.(uint64)
+		members := []string{}
+		for i, member := range sqlType.Values() {
+			if bitField&(1<<uint(i)) != 0 {
+				members = append(members, member)
+			}
+		}
+		return members, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// jsonColumnValue decodes the value of a JSON column into the structure it holds, so that it's written as nested JSON
+// rather than as an encoded string. A document holding the JSON null literal is written as null, unlike a NULL column
+// value, which never reaches here and follows the writer's NULL policy like NULLs of other types.
+func (j *RowWriter) jsonColumnValue(col schema.Column, val interface{}) (interface{}, error) {
+	if j.normalizeJSON {
+		nested, err := j.normalizedJSONColumnValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s' holds invalid JSON: %w", col.Name, err)
+		}
+		return nested, nil
+	}
+
+	jsVal, ok := val.(sql.JSONValue)
+	if !ok {
+		converted, err := sql.JSON.Convert(val)
+		if err != nil {
+			return nil, err
+		}
+		jsVal = converted.(sql.JSONValue)
+	}
+
+	doc, err := jsVal.Unmarshall(sql.NewEmptyContext())
+	if err != nil {
+		return nil, err
+	}
+
+	if j.nestedKeyTransform && j.keyNameFunc != nil {
+		return transformNestedKeys(doc.Val, j.keyNameFunc)
+	}
+	return doc.Val, nil
+}
+
+// transformKeys returns a copy of |obj| with |fn| applied to each key, erroring if two keys transform to the same name.
+// Keys are transformed in sorted order, so that the keys reported for a collision don't depend on map iteration.
+func transformKeys(obj map[string]interface{}, fn func(string) string) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	transformed := make(map[string]interface{}, len(obj))
+	origKeys := make(map[string]string, len(obj))
+	for _, k := range keys {
+		newKey := fn(k)
+		if origKey, ok := origKeys[newKey]; ok {
+			return nil, fmt.Errorf("keys '%s' and '%s' are both transformed to the name '%s'", origKey, k, newKey)
+		}
+		origKeys[newKey] = k
+		transformed[newKey] = obj[k]
+	}
+	return transformed, nil
+}
+
+// normalizedJSONColumnValue is jsonColumnValue for writers normalizing JSON columns. Stored text is canonicalized
+// directly, rather than through a decoded document, so that large integers keep their exact digits.
+func (j *RowWriter) normalizedJSONColumnValue(val interface{}) (interface{}, error) {
+	var data []byte
+	switch v := val.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case sql.JSONValue:
+		str, err := v.ToString(sql.NewEmptyContext())
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(str)
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	nested, err := canonicalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.nestedKeyTransform && j.keyNameFunc != nil {
+		return transformNestedKeys(nested, j.keyNameFunc)
+	}
+	return nested, nil
+}
+
+// transformNestedKeys applies |fn| to the keys of every object nested within |val|, including objects within arrays
+func transformNestedKeys(val interface{}, fn func(string) string) (interface{}, error) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		obj, err := transformKeys(v, fn)
+		if err != nil {
+			return nil, err
+		}
+		for k, nested := range obj {
+			if obj[k], err = transformNestedKeys(nested, fn); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, nested := range v {
+			var err error
+			if arr[i], err = transformNestedKeys(nested, fn); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	default:
+		return val, nil
+	}
+}
+
+// applyCoalesces sets each coalesce target to the first of its sources present in |colValMap|. NULL values are never
+// present in the map, so a missing key is a NULL source.
+func (j *RowWriter) applyCoalesces(colValMap map[string]interface{}) {
+	for _, c := range j.coalesces {
+		var found bool
+		var val interface{}
+		for _, src := range c.sources {
+			// a present nil is a NULL written as JSON null
+			if val, found = colValMap[src]; found && val != nil {
+				break
+			}
+			found = false
+		}
+
+		if c.dropSources {
+			for _, src := range c.sources {
+				delete(colValMap, src)
+			}
+		}
+
+		if found {
+			colValMap[c.target] = val
+		}
+	}
+}
+
+// writeLeadingRowCount overwrites the row count placeholder at the top of the document, then returns the destination
+// to the end of the document. All buffered bytes must be flushed first.
+func (j *RowWriter) writeLeadingRowCount() error {
+	end, err := j.countSeeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.countSeeker.Seek(j.countOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	err = iohelp.WriteAll(j.countSeeker, []byte(fmt.Sprintf("%*d", rowCountWidth, j.rowsWritten)))
+	if err != nil {
+		return err
+	}
+
+	_, err = j.countSeeker.Seek(end, io.SeekStart)
+	return err
+}
+
+// footerField is an extra top-level field of the default json envelope, written after the rows
+type footerField struct {
+	key string
+	val interface{}
+}
+
+// hasFooterFields returns whether the writer adds any fields to the footer of the envelope
+func (j *RowWriter) hasFooterFields() bool {
+	return j.checksumAlgo != "" || j.lossinessWarnings || len(j.profiles) > 0 || j.footerRowCount || j.footerMetadata != nil
+}
+
+func (j *RowWriter) footerFields() ([]footerField, error) {
+	var fields []footerField
+	if j.footerRowCount {
+		fields = append(fields, footerField{key: rowCountKey, val: j.rowsWritten})
+	}
+	if j.lossinessWarnings {
+		warnings := j.warnings
+		if warnings == nil {
+			warnings = []lossinessWarning{}
+		}
+		fields = append(fields, footerField{key: "warnings", val: warnings})
+	}
+	if len(j.profiles) > 0 {
+		profile := make(map[string]interface{}, len(j.profiles))
+		for _, p := range j.profiles {
+			profile[p.col] = p.summary()
+		}
+		fields = append(fields, footerField{key: "profile", val: profile})
+	}
+
+	if j.footerMetadata != nil {
+		metadata := j.footerMetadata()
+		keys := make([]string, 0, len(metadata))
+		for key := range metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if j.isEnvelopeKey(key, fields) {
+				return nil, fmt.Errorf("footer metadata key '%s' conflicts with a field of the envelope", key)
+			}
+			fields = append(fields, footerField{key: key, val: metadata[key]})
+		}
+	}
+
+	return fields, nil
+}
+
+// isEnvelopeKey returns whether |key| is the key of the rows array, the checksum, or one of the footer fields given
+func (j *RowWriter) isEnvelopeKey(key string, fields []footerField) bool {
+	if key == checksumKey || key == rowCountKey {
+		return true
+	}
+	for _, f := range fields {
+		if f.key == key {
+			return true
+		}
+	}
+
+	encKey, err := json.Marshal(key)
+	return err == nil && strings.Contains(j.header, string(encKey)+": [")
+}
+
+func (j *RowWriter) writeFooter() error {
+	fields, err := j.footerFields()
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 && j.docHash == nil {
+		return iohelp.WriteAll(j.out, []byte(j.footer))
+	}
+
+	err = iohelp.WriteAll(j.out, []byte("]"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		data, err := j.marshalJSON(f.val)
+		if err != nil {
+			return fmt.Errorf("error marshaling footer field '%s': %w", f.key, err)
+		}
+
+		encKey, err := j.marshalJSON(f.key)
+		if err != nil {
+			return err
+		}
+		err = iohelp.WriteAll(j.out, []byte(","), encKey, []byte(":"), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.docHash == nil {
+		return iohelp.WriteAll(j.out, []byte("}"))
+	}
+
+	// the checksum covers every byte of the document preceding it, so it must be the last field written
+	err = iohelp.WriteAll(j.out, []byte(","))
+	if err != nil {
+		return err
+	}
+
+	err = j.bWr.Flush()
+	if err != nil {
+		return err
+	}
+
+	return iohelp.WriteAll(j.out, []byte(fmt.Sprintf(`"%s":"%s:%x"}`, checksumKey, j.checksumAlgo, j.docHash.Sum(nil))))
+}
+
+// formatTime formats a TIME value as [-]HH:MM:SS[.ffffff]. TIME type info has no declared precision, so every column
+// is treated as TIME(6), and at that precision the fraction is written only when non-zero, matching the SQL
+// representation of the value. A fixed number
+// of fractional digits set with |WithTimeFractionalSeconds| is always written, truncating any further precision.
+func (j *RowWriter) formatTime(col schema.Column, ts sql.Timespan) string {
+	if micros := ts.AsMicroseconds(); j.datetimeLayout != "" && micros >= 0 && micros < microsPerDay {
+		j.checkLayoutFraction(col, int(micros%1000000)*1000)
+		return zeroDatetime.Add(time.Duration(micros) * time.Microsecond).Format(j.datetimeLayout)
+	}
+
+	if j.timeFracDigits < 0 {
+		return ts.String()
+	}
+
+	micros := ts.AsMicroseconds()
+	sign := ""
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+
+	secs := micros / 1000000
+	str := fmt.Sprintf("%s%02d:%02d:%02d", sign, secs/3600, (secs/60)%60, secs%60)
+	frac := fmt.Sprintf("%06d", micros%1000000)
+	if strings.TrimRight(frac[j.timeFracDigits:], "0") != "" {
+		j.warnLossy(col, fmt.Sprintf("fractional seconds truncated to %d digits", j.timeFracDigits))
+	}
+
+	if j.timeFracDigits == 0 {
+		return str
+	}
+	return str + "." + frac[:j.timeFracDigits]
+}
+
+// formatDatetime formats the value of a DATE, DATETIME or TIMESTAMP column with the writer's layout. DATE values are
+// formatted in UTC, since they have no time of day to convert, and other values in the writer's location. The zero
+// date that MySQL permits isn't a real instant, so it's written as MySQL writes it, e.g. "0000-00-00 00:00:00",
+// whatever the layout.
+func (j *RowWriter) formatDatetime(col schema.Column, t time.Time) string {
+	isDate := col.TypeInfo.ToSqlType().Type() == sqltypes.Date
+	if t.Equal(zeroDatetime) {
+		if isDate {
+			return zeroDateStr
+		}
+		return zeroDatetimeStr
+	}
+
+	if isDate {
+		return t.UTC().Format(j.datetimeLayout)
+	}
+	j.checkLayoutFraction(col, t.Nanosecond())
+	return t.In(j.datetimeLoc).Format(j.datetimeLayout)
+}
+
+// checkLayoutFraction warns when the datetime layout doesn't write all of the |nanos| fractional second of a value
+func (j *RowWriter) checkLayoutFraction(col schema.Column, nanos int) {
+	unit := 1
+	for i := j.datetimeFracDigits; i < 9; i++ {
+		unit *= 10
+	}
+	if nanos%unit != 0 {
+		j.warnLossy(col, fmt.Sprintf("fractional seconds truncated to %d digits by the datetime layout", j.datetimeFracDigits))
+	}
+}
+
+// layoutFracDigits returns the number of fractional second digits written by the Go time |layout|, which are given
+// by a run of 0s or 9s following a period or comma
+func layoutFracDigits(layout string) int {
+	digits := 0
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '.' && layout[i] != ',' || i+1 == len(layout) || (layout[i+1] != '0' && layout[i+1] != '9') {
+			continue
+		}
+		j := i + 1
+		for j < len(layout) && layout[j] == layout[i+1] {
+			j++
+		}
+		if (j == len(layout) || layout[j] < '0' || layout[j] > '9') && j-i-1 > digits {
+			digits = j - i - 1
+		}
+		i = j - 1
+	}
+	return digits
+}
+
+// warnLossy records that the value of |col| in the row being written lost fidelity when converted for output
+func (j *RowWriter) warnLossy(col schema.Column, reason string) {
+	if j.lossinessWarnings {
+		j.warnings = append(j.warnings, lossinessWarning{Row: j.rowsWritten, Column: col.Name, Reason: reason})
+	}
+}
+
+// typeTagged is a value written along with the name of its type
+type typeTagged struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// countingWriter counts the bytes written through it to |wr|
+type countingWriter struct {
+	wr io.Writer
+	n  int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.wr.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// tableRow is a row wrapped in an object naming the table it's from
+type tableRow struct {
+	Table string      `json:"table"`
+	Row   interface{} `json:"row"`
+}
+
+// geoJSON is a GeoJSON geometry object, as written by ST_AsGeoJSON
+type geoJSON struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// verboseDecimal is the loss-free representation of a DECIMAL value, carrying the precision and scale of its column
+type verboseDecimal struct {
+	Value     string `json:"value"`
+	Precision uint8  `json:"precision"`
+	Scale     uint8  `json:"scale"`
+}
+
+func newVerboseDecimal(col schema.Column, str string) verboseDecimal {
+	decType := col.TypeInfo.ToSqlType().(sql.DecimalType)
+	return verboseDecimal{Value: str, Precision: decType.Precision(), Scale: decType.Scale()}
+}
+
+// nonFiniteFloat returns the value written for a NaN or infinite float, according to the writer's policy
+func (j *RowWriter) nonFiniteFloat(col schema.Column, f float64) (interface{}, error) {
+	switch j.nonFinitePolicy {
+	case NonFiniteAsNull:
+		j.warnLossy(col, fmt.Sprintf("%v written as null", f))
+		return nil, nil
+	case NonFiniteAsString:
+		switch {
+		case math.IsNaN(f):
+			return "NaN", nil
+		case f > 0:
+			return "Infinity", nil
+		default:
+			return "-Infinity", nil
+		}
+	default:
+		return nil, fmt.Errorf("column '%s' holds %v, which can't be written as a JSON number", col.Name, f)
+	}
+}
+
+// numericBool returns the integer form of a boolean, as emitted in SQL replay mode
+func numericBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// valueText returns the text of a value: the string for values written as JSON strings, and the JSON encoding of any
+// other value
+func valueText(val interface{}) (string, error) {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+
+	var str string
+	if json.Unmarshal(b, &str) == nil {
+		return str, nil
+	}
+	return string(b), nil
+}
+
+// marshalJSON encodes a value as JSON, escaping HTML characters in strings unless the writer is configured not to
+func (j *RowWriter) marshalJSON(v interface{}) ([]byte, error) {
+	return encodeJSON(v, j.escapeHTML)
+}
+
+// encodeJSON encodes a value as JSON like json.Marshal, optionally without escaping the HTML characters <, > and &
+func encodeJSON(v interface{}, escapeHTML bool) ([]byte, error) {
+	if escapeHTML {
+		return marshalToJson(v)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
 func marshalToJson(valMap interface{}) ([]byte, error) {
 	var jsonBytes []byte
 	var err error
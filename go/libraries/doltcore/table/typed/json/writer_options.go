@@ -0,0 +1,697 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+)
+
+// WriterOption configures optional behavior of a RowWriter. Options are applied in order by the constructors once the
+// writer's schema has been set, and an error returned by any option aborts construction.
+type WriterOption func(j *RowWriter) error
+
+// WithSQLReplay configures the writer for output that will be replayed as SQL by a strict consumer. In this mode BOOL
+// columns are always emitted as the integers 1 and 0 rather than JSON true and false, which aren't valid in a MySQL
+// numeric context.
+func WithSQLReplay(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.sqlReplay = enabled
+		return nil
+	}
+}
+
+// WithDocumentChecksum computes a running hash of every byte written and emits it as a trailing "checksum" field of
+// the envelope, formatted as "<algorithm>:<hex digest>". Because the checksum covers all prior bytes it is written
+// last, in Close. Use |VerifyDocumentChecksum| to check a document. Requires the default json envelope.
+func WithDocumentChecksum(algo ChecksumAlgorithm) WriterOption {
+	return func(j *RowWriter) error {
+		if _, ok := checksumHashes[algo]; !ok {
+			return fmt.Errorf("unknown checksum algorithm '%s'", algo)
+		}
+		j.checksumAlgo = algo
+		return nil
+	}
+}
+
+type coalesce struct {
+	target      string
+	sources     []string
+	dropSources bool
+}
+
+// WithCoalesce adds a field named |targetField| to each row holding the first non-NULL value among |sourceCols|, in
+// the order given. When every source is NULL the target is NULL as well. If |dropSources| is true the source columns
+// are removed from the output. All sources must exist in the schema and have compatible types.
+func WithCoalesce(targetField string, sourceCols []string, dropSources bool) WriterOption {
+	return func(j *RowWriter) error {
+		if len(sourceCols) == 0 {
+			return fmt.Errorf("coalesce into '%s' requires at least one source column", targetField)
+		}
+
+		for _, c := range j.coalesces {
+			if c.target == targetField {
+				return fmt.Errorf("duplicate coalesce target '%s'", targetField)
+			}
+		}
+
+		j.coalesces = append(j.coalesces, coalesce{target: targetField, sources: sourceCols, dropSources: dropSources})
+		return nil
+	}
+}
+
+// typeClass groups type identifiers whose values may stand in for one another in the output
+func typeClass(id typeinfo.Identifier) string {
+	switch id {
+	case typeinfo.BitTypeIdentifier,
+		typeinfo.BoolTypeIdentifier,
+		typeinfo.DecimalTypeIdentifier,
+		typeinfo.FloatTypeIdentifier,
+		typeinfo.IntTypeIdentifier,
+		typeinfo.UintTypeIdentifier,
+		typeinfo.YearTypeIdentifier:
+		return "numeric"
+	case typeinfo.BlobStringTypeIdentifier,
+		typeinfo.EnumTypeIdentifier,
+		typeinfo.InlineBlobTypeIdentifier,
+		typeinfo.SetTypeIdentifier,
+		typeinfo.UuidTypeIdentifier,
+		typeinfo.VarBinaryTypeIdentifier,
+		typeinfo.VarStringTypeIdentifier:
+		return "string"
+	case typeinfo.DatetimeTypeIdentifier,
+		typeinfo.TimeTypeIdentifier:
+		return "temporal"
+	case typeinfo.GeometryTypeIdentifier,
+		typeinfo.LineStringTypeIdentifier,
+		typeinfo.PointTypeIdentifier,
+		typeinfo.PolygonTypeIdentifier:
+		return "spatial"
+	default:
+		return string(id)
+	}
+}
+
+func containsStr(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// WithWriteLimiter throttles the bytes written to the destination to the rate of |lim|, taking one token per byte.
+// The writer waits on the limiter each time it passes a buffered batch to the destination, and the wait is abandoned
+// with the context's error if the context of the in-progress call is cancelled. A limiter with a finite rate must have
+// a positive burst, since no write could ever proceed otherwise.
+func WithWriteLimiter(lim *rate.Limiter) WriterOption {
+	return func(j *RowWriter) error {
+		if lim == nil {
+			return errors.New("write limiter must not be nil")
+		}
+		if lim.Limit() != rate.Inf && lim.Burst() <= 0 {
+			return fmt.Errorf("write limiter burst must be positive, got %d", lim.Burst())
+		}
+		j.limiter = lim
+		return nil
+	}
+}
+
+// WithTimeFractionalSeconds overrides the precision of TIME values, always writing exactly |digits| fractional second
+// digits. Digits beyond the stored microsecond precision of a value are truncated, not rounded. The TIME type info of
+// this tree carries no declared precision, so a column declared as, e.g., TIME(3) can't be written with 3 digits by
+// default: without this option every TIME value is written at microsecond precision, with its fraction omitted when
+// it's zero and trailing zeros kept otherwise, as in "12:00:00" and "01:02:03.500000".
+func WithTimeFractionalSeconds(digits int) WriterOption {
+	return func(j *RowWriter) error {
+		if digits < 0 || digits > 6 {
+			return fmt.Errorf("fractional seconds digits must be between 0 and 6, got %d", digits)
+		}
+		j.timeFracDigits = digits
+		return nil
+	}
+}
+
+// WithDatetimeLayout writes DATETIME, TIMESTAMP and DATE values formatted with the Go time |layout|, e.g.
+// time.RFC3339, after converting them to |loc|, or UTC if |loc| is nil. DATE values are never converted, since they
+// have no time of day, and the zero date 0000-00-00 that MySQL permits is written as MySQL writes it whatever the
+// layout. TIME values between 00:00:00 and 23:59:59.999999 are formatted as times of day of 0000-01-01, with no
+// zone conversion, so a layout for them should only use clock fields, e.g. "15:04:05". Other TIME values, which are
+// durations rather than times of day, are written as they are by default. Without a layout, values are written as
+// MySQL writes them.
+func WithDatetimeLayout(layout string, loc *time.Location) WriterOption {
+	return func(j *RowWriter) error {
+		if layout == "" {
+			return errors.New("datetime layout must not be empty")
+		}
+		if loc == nil {
+			loc = time.UTC
+		}
+		j.datetimeLayout = layout
+		j.datetimeLoc = loc
+		j.datetimeFracDigits = layoutFracDigits(layout)
+		return nil
+	}
+}
+
+// WithLeadingRowCount writes a "row_count" field ahead of the rows without buffering the export. The writer reserves
+// a fixed width placeholder at the top of the document and, in Close, seeks back to fill in the real count. This only
+// applies when the destination is an io.WriteSeeker whose Seek succeeds; for other destinations the count is omitted.
+// Requires the default json envelope.
+func WithLeadingRowCount(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.leadingRowCount = enabled
+		return nil
+	}
+}
+
+// WithFooterRowCount writes the number of rows written as a "row_count" field following the rows, e.g.
+// {"rows": [...], "row_count": 12345}, for validating an export once it's complete. Unlike |WithLeadingRowCount| it
+// works with any destination. Requires the default json envelope.
+func WithFooterRowCount(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.footerRowCount = enabled
+		return nil
+	}
+}
+
+// WithFooterMetadata writes the fields returned by |fn| following the rows, e.g. the name of the table exported and
+// the time of the export, in sorted key order after any other footer fields but ahead of the checksum. |fn| is called
+// once, in Close, and a key that conflicts with another field of the envelope fails the close. Requires the default
+// json envelope.
+func WithFooterMetadata(fn func() map[string]interface{}) WriterOption {
+	return func(j *RowWriter) error {
+		j.footerMetadata = fn
+		return nil
+	}
+}
+
+// WithLossinessWarnings collects a warning for each value whose output representation loses fidelity, and emits them
+// in a "warnings" array of the envelope footer. Each warning gives the zero based row index, the column, and the
+// reason. Requires the default json envelope. Warnings are given for exactly these conversions:
+//   - TIME values with more fractional second digits than |WithTimeFractionalSeconds| writes
+//   - DATETIME, TIMESTAMP and TIME values with more fractional second digits than the layout of |WithDatetimeLayout|
+//     writes, e.g. a value with milliseconds written with time.RFC3339
+//   - binary values that aren't valid UTF-8 written with |BinaryRaw|
+//   - non-finite floats written as null by |NonFiniteAsNull|
+//
+// Other values are written exactly: strings are never truncated, and decimals are written as strings, or as numbers
+// holding all of their digits with |WithDecimalsAsNumbers|, never as floats.
+func WithLossinessWarnings(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.lossinessWarnings = enabled
+		return nil
+	}
+}
+
+// WithKeyNameFunc transforms the name of every top-level field written, such as converting column names to
+// snake_case. It's an error for two fields of a row to transform to the same name.
+func WithKeyNameFunc(fn func(name string) string) WriterOption {
+	return func(j *RowWriter) error {
+		j.keyNameFunc = fn
+		return nil
+	}
+}
+
+// WithNestedKeyTransform applies the function given to |WithKeyNameFunc| to the keys of objects nested within JSON
+// columns as well, recursing through nested objects and arrays, so that key casing is uniform across the document.
+func WithNestedKeyTransform(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.nestedKeyTransform = enabled
+		return nil
+	}
+}
+
+// WithNestedColumnPaths writes columns whose names contain |delim| as fields of nested objects, splitting each name
+// into the path of its field, so that e.g. columns "address.city" and "address.zip" are written as
+// {"address":{"city":...,"zip":...}} with a delimiter of ".". Names are split after any function given to
+// |WithKeyNameFunc| is applied. It's an error for a field's path to run through another field, such as a column
+// "address" along with "address.city". Columns are written as top-level fields if |delim| is "", which is the default.
+func WithNestedColumnPaths(delim string) WriterOption {
+	return func(j *RowWriter) error {
+		j.pathDelim = delim
+		return nil
+	}
+}
+
+// WithGlobalSequence adds a "_seq" field to each row holding a sequence number that continues across export runs.
+// The first row written gets |start| and each subsequent row the next integer, so to continue a previous export pass
+// one more than the last sequence number it wrote. Unlike a per-run row ordinal, the sequence number gives each row a
+// durable identity, but only if |start| is persisted and supplied correctly: a |start| at or below a previously
+// written number reuses sequence numbers, and nothing in the writer can detect the collision.
+func WithGlobalSequence(start int64) WriterOption {
+	return func(j *RowWriter) error {
+		j.globalSeq = true
+		j.globalSeqStart = start
+		return nil
+	}
+}
+
+// WithDecimalVerbose writes DECIMAL values as objects holding the value string along with the precision and scale of
+// the column, e.g. {"value": "123.45", "precision": 10, "scale": 2}, so consumers can reconstruct exact decimal
+// semantics. NULL decimals are still omitted.
+func WithDecimalVerbose(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.decimalVerbose = enabled
+		return nil
+	}
+}
+
+// LineEnding is the line terminator used by line delimited output
+type LineEnding string
+
+const (
+	LF   LineEnding = "\n"
+	CRLF LineEnding = "\r\n"
+)
+
+// WithLineEnding sets the line ending written between the objects of line delimited output, for consumers that
+// require CRLF. The default is LF. Output that isn't line delimited, like the default "rows" array, is unaffected.
+func WithLineEnding(le LineEnding) WriterOption {
+	return func(j *RowWriter) error {
+		if le != LF && le != CRLF {
+			return fmt.Errorf("unsupported line ending %q", string(le))
+		}
+		j.lineEnding = le
+		return nil
+	}
+}
+
+// WithBatchMarkers writes a {"_batch_end":true} line at each flush boundary of line delimited output, so a consumer
+// reading over a streaming connection can commit the rows before each marker as a batch. A marker is written by each
+// Flush that follows at least one row, and by Close for any rows remaining in the final batch. A reader created with
+// |NewNDJSONReader| skips the markers.
+func WithBatchMarkers(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.batchMarkers = enabled
+		return nil
+	}
+}
+
+// WithNullStringsAsEmpty writes NULL values of nullable text columns as empty strings instead of omitting them, for
+// consumers that don't distinguish the two. Genuinely empty strings become indistinguishable from NULL, so a reader
+// created with |WithEmptyStringPolicy(EmptyStringAsNull)| reads both back as NULL.
+func WithNullStringsAsEmpty(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.nullStringsAsEmpty = enabled
+		return nil
+	}
+}
+
+// WithHashBucketing assigns each row to one of |n| buckets by hashing its primary key, and writes the document
+// {"buckets": {"0": [...], "1": [...], ...}} in place of the "rows" array. The bucket of a row is the FNV-1a 64 bit
+// hash of the JSON array of its primary key values, in primary key order and encoded exactly as they're written,
+// modulo |n|, e.g. FNV-1a("[42,\"abc\"]") % n. It depends on nothing but the key values, so a row lands in the same
+// bucket in every run. Rows are held in memory until Close, since each bucket must be written contiguously. Requires a
+// schema with a primary key and the default json envelope.
+func WithHashBucketing(n int) WriterOption {
+	return func(j *RowWriter) error {
+		if n <= 0 {
+			return fmt.Errorf("number of hash buckets must be positive, got %d", n)
+		}
+		j.bucketCount = n
+		return nil
+	}
+}
+
+// WithTypeTagsForAmbiguous wraps the values of columns that are written as strings indistinguishable from plain text
+// in a minimal type tag, e.g. {"type": "date", "value": "2022-03-04"}, and leaves every other value bare. The columns
+// considered ambiguous, and their tags, are:
+//   - DATE, DATETIME and TIMESTAMP: "date", "datetime" and "timestamp"
+//   - TIME: "time"
+//   - DECIMAL: "decimal", unless |WithDecimalVerbose| or |WithDecimalsAsNumbers| makes it unambiguous
+//   - BINARY, VARBINARY and BLOB: "binary"
+//
+// Text, numeric, boolean, JSON and all other columns are never tagged. NULL values are still omitted.
+func WithTypeTagsForAmbiguous(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.typeTagAmbiguous = enabled
+		return nil
+	}
+}
+
+// WithEnvelopeValidation sets whether |NewJSONWriterWithHeader| checks that its header, footer and separator make a
+// valid JSON document. Validation is enabled by default, and can be disabled to write fragments of a document.
+func WithEnvelopeValidation(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.skipEnvelopeValidation = !enabled
+		return nil
+	}
+}
+
+// WithTableWrapping wraps each row written in an object naming the table it's from, {"table":"<table>","row":{...}},
+// so that consumers of a stream merging the rows of several tables can tell them apart. The envelope is unchanged.
+func WithTableWrapping(table string) WriterOption {
+	return func(j *RowWriter) error {
+		if table == "" {
+			return errors.New("table name must not be empty")
+		}
+		j.tableName = table
+		return nil
+	}
+}
+
+// WithNormalizeJSONColumns validates the value of each JSON column and writes it in canonical form: object keys sorted
+// and no insignificant whitespace. Numbers are normalized as well, with integers keeping their exact digits, so the
+// nested JSON of a value is the same however its stored text was formatted. A value that isn't valid JSON is an error
+// naming its column.
+func WithNormalizeJSONColumns(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.normalizeJSON = enabled
+		return nil
+	}
+}
+
+// WithColumnProfile tracks the distinct values written for each of the named columns, which should be of low
+// cardinality, and emits a "profile" object in the envelope footer giving each column's count of non-NULL values and
+// the count of each distinct value, e.g. "profile": {"status": {"count": 3, "values": {"active": 2, "closed": 1}}}.
+// Values are counted by their written form, with non-string values keyed by their JSON encoding. A column with more
+// than 100 distinct values is reported as {"count": n, "high_cardinality": true} and its values are discarded, so a
+// profile holds at most 100 values per column in memory. Requires the default json envelope.
+func WithColumnProfile(cols []string) WriterOption {
+	return func(j *RowWriter) error {
+		for _, name := range cols {
+			for _, p := range j.profiles {
+				if p.col == name {
+					return fmt.Errorf("duplicate profiled column '%s'", name)
+				}
+			}
+			j.profiles = append(j.profiles, newColumnProfile(name))
+		}
+		return nil
+	}
+}
+
+// WithFieldOmitPredicate omits each field for which |fn| returns true from its row, in addition to the NULL fields that
+// are always omitted, e.g. to drop empty strings or zero values from the output. |fn| is called for every non-NULL
+// column value with the value as it will be written: strings for the types written as strings, decoded structures for
+// JSON columns, and otherwise the Go value of the column type, e.g. int64 for a BIGINT column. Values are the same for
+// rows written with WriteRow and WriteSqlRow. Omission happens before coalescing, so an omitted source is skipped like
+// a NULL one.
+func WithFieldOmitPredicate(fn func(col schema.Column, val interface{}) bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.omitField = fn
+		return nil
+	}
+}
+
+// WithHashChain links the rows of the output into a tamper-evident chain: each row carries a "_prev_hash" field holding
+// the hex encoded SHA-256 of the previous row's bytes exactly as written, with its fields in the writer's field order.
+// Altering, removing or reordering any row breaks the chain at the following row. The first row's "_prev_hash" is
+// |DefaultHashChainGenesis| unless set with |WithHashChainGenesis|. Use |VerifyHashChain| to check a document, or
+// |VerifyHashChainWithKey| for one written with a rows key other than "rows".
+func WithHashChain(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.hashChain = enabled
+		return nil
+	}
+}
+
+// WithHashChainGenesis sets the "_prev_hash" of the first row of a hash chain, e.g. to the hash of the last row of a
+// previous export so that the chain continues across exports.
+func WithHashChainGenesis(genesis string) WriterOption {
+	return func(j *RowWriter) error {
+		j.prevHash = genesis
+		return nil
+	}
+}
+
+// WithAllValuesAsStrings writes every non-NULL value as a JSON string, for consumers that expect a document of
+// strings only. Values that would otherwise be written as strings are unchanged, and all others are written as the
+// text of their JSON encoding, e.g. 42 as "42", true as "true", and a JSON column as its encoded document. NULL values
+// are omitted unless configured otherwise with |WithAllStringsNull|.
+func WithAllValuesAsStrings(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.allStrings = enabled
+		return nil
+	}
+}
+
+// NullRepresentation is how NULL values are written by a writer in all-strings mode
+type NullRepresentation int
+
+const (
+	// NullOmitted omits NULL fields from their row, as when not in all-strings mode
+	NullOmitted NullRepresentation = iota
+	// NullAsJSONNull writes NULL fields as the JSON null
+	NullAsJSONNull
+	// NullAsToken writes NULL fields as a configured string, such as "NULL" or ""
+	NullAsToken
+)
+
+// WithAllStringsNull sets how NULL values are written when |WithAllValuesAsStrings| is enabled. |token| is the string
+// written for NULL values with |NullAsToken|, and is ignored otherwise. Like |WithNullStringsAsEmpty|, which takes
+// precedence for the columns it applies to, a NULL written as a token is indistinguishable from that string and counts
+// as a value when coalescing; a NULL written as JSON null doesn't. Has no effect without |WithAllValuesAsStrings|.
+func WithAllStringsNull(rep NullRepresentation, token string) WriterOption {
+	return func(j *RowWriter) error {
+		if rep < NullOmitted || rep > NullAsToken {
+			return fmt.Errorf("unknown NULL representation %d", rep)
+		}
+		j.allStringsNull = rep
+		j.nullToken = token
+		return nil
+	}
+}
+
+// WithSurrogateID adds a field named |fieldName| to each row of a keyless schema holding a surrogate id, so that sinks
+// requiring a key can consume keyless exports. Ids increase monotonically from 1 within an export and restart at 1 in
+// the next, so they aren't stable across runs. Combined with |WithGlobalSequence| each row's id is its global sequence
+// number instead, which continues across runs when the sequence is resumed correctly. Keyed schemas are an error, as
+// their rows have a key already.
+func WithSurrogateID(fieldName string) WriterOption {
+	return func(j *RowWriter) error {
+		if fieldName == "" {
+			return errors.New("surrogate id field name must not be empty")
+		}
+		j.surrogateIDField = fieldName
+		return nil
+	}
+}
+
+// WithIncludeNulls writes NULL column values as JSON null rather than omitting them, so that every row has a field for
+// every column, as consumers loading rows into a strict schema expect. The NULL representations configured by
+// |WithNullStringsAsEmpty| and |WithAllStringsNull| take precedence for the columns they apply to.
+func WithIncludeNulls(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.includeNulls = enabled
+		return nil
+	}
+}
+
+// NonFiniteFloatPolicy is how a writer handles NaN and infinite float values, which JSON numbers can't represent
+type NonFiniteFloatPolicy int
+
+const (
+	// NonFiniteAsError fails the write of a row holding a non-finite float
+	NonFiniteAsError NonFiniteFloatPolicy = iota
+	// NonFiniteAsNull writes non-finite floats as the JSON null
+	NonFiniteAsNull
+	// NonFiniteAsString writes non-finite floats as the strings "NaN", "Infinity" and "-Infinity"
+	NonFiniteAsString
+)
+
+// WithNonFiniteFloats sets how NaN, +Inf and -Inf values of float columns are written. The default,
+// |NonFiniteAsError|, returns an error naming the column from the write of the row, before any of it is written.
+func WithNonFiniteFloats(policy NonFiniteFloatPolicy) WriterOption {
+	return func(j *RowWriter) error {
+		if policy < NonFiniteAsError || policy > NonFiniteAsString {
+			return fmt.Errorf("unknown non-finite float policy %d", policy)
+		}
+		j.nonFinitePolicy = policy
+		return nil
+	}
+}
+
+// WithDecimalsAsNumbers writes DECIMAL values as JSON numbers rather than strings, e.g. 19.99 rather than "19.99", for
+// consumers that aggregate them numerically. The number is written with the exact digits of the value, never through
+// a float64, so no precision is lost, though consumers that parse JSON numbers as doubles may lose it on read. Integer
+// columns of every width are always written as exact numbers. |WithDecimalVerbose| takes precedence.
+func WithDecimalsAsNumbers(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.decimalsAsNumbers = enabled
+		return nil
+	}
+}
+
+// WithEscapeHTML sets whether the characters <, > and & in strings are written as \u003c, \u003e and \u0026, as they
+// are by default so that the output is safe to embed in HTML. Disabling escaping writes them as they are, as most
+// other JSON tools do, which keeps string columns holding HTML readable.
+func WithEscapeHTML(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.escapeHTML = enabled
+		return nil
+	}
+}
+
+// WithBufferSize sets the size in bytes of the writer's write buffer, overriding |WriteBufSize|. Rows are passed to
+// the destination each time the buffer fills, so larger buffers suit high throughput exports, and smaller ones save
+// memory when many writers are open at once.
+func WithBufferSize(size int) WriterOption {
+	return func(j *RowWriter) error {
+		if size <= 0 {
+			return fmt.Errorf("buffer size must be positive, got %d", size)
+		}
+		j.bufSize = size
+		return nil
+	}
+}
+
+// WithSetsAsArrays writes SET values as JSON arrays of their members in the order of the column definition, e.g.
+// ["a","c"] rather than "a,c", so that consumers don't have to split the string. An empty set is written as [].
+func WithSetsAsArrays(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.setsAsArrays = enabled
+		return nil
+	}
+}
+
+// WithEnumsAsIndexes writes ENUM values as their numeric index, starting from 1 for the first value of the column
+// definition, rather than as their string label.
+func WithEnumsAsIndexes(enabled bool) WriterOption {
+	return func(j *RowWriter) error {
+		j.enumsAsIndexes = enabled
+		return nil
+	}
+}
+
+// BinaryEncoding is how a writer encodes the bytes of binary and blob columns in JSON strings
+type BinaryEncoding string
+
+const (
+	// BinaryRaw writes the bytes as they are, which only suits columns holding valid UTF-8 text. Invalid UTF-8 is
+	// replaced with the Unicode replacement character, so other bytes are lost.
+	BinaryRaw BinaryEncoding = "raw"
+	// BinaryBase64 writes the bytes in standard base64 encoding, which preserves any bytes
+	BinaryBase64 BinaryEncoding = "base64"
+)
+
+// WithBinaryEncoding sets how the values of BINARY, VARBINARY and BLOB columns are encoded. The default is |BinaryRaw|.
+func WithBinaryEncoding(enc BinaryEncoding) WriterOption {
+	return func(j *RowWriter) error {
+		if enc != BinaryRaw && enc != BinaryBase64 {
+			return fmt.Errorf("unknown binary encoding '%s'", enc)
+		}
+		j.binaryEncoding = enc
+		return nil
+	}
+}
+
+// SpatialEncoding is how a writer encodes the values of geometry columns
+type SpatialEncoding string
+
+const (
+	// SpatialGeoJSON writes each value as a GeoJSON geometry object, e.g. {"type":"Point","coordinates":[1,2]}, as
+	// written by ST_AsGeoJSON
+	SpatialGeoJSON SpatialEncoding = "geojson"
+	// SpatialWKT writes each value as a WKT string, e.g. "POINT(1 2)", as written by ST_AsWKT
+	SpatialWKT SpatialEncoding = "wkt"
+)
+
+// WithSpatialEncoding sets how the values of GEOMETRY, POINT, LINESTRING and POLYGON columns are encoded. The default
+// is |SpatialGeoJSON|.
+func WithSpatialEncoding(enc SpatialEncoding) WriterOption {
+	return func(j *RowWriter) error {
+		if enc != SpatialGeoJSON && enc != SpatialWKT {
+			return fmt.Errorf("unknown spatial encoding '%s'", enc)
+		}
+		j.spatialEncoding = enc
+		return nil
+	}
+}
+
+// BitEncoding is how a writer encodes the values of BIT columns
+type BitEncoding string
+
+const (
+	// BitInteger writes each value as a JSON number
+	BitInteger BitEncoding = "integer"
+	// BitBoolean writes the values of BIT(1) columns as JSON true and false. It's an error to write a wider column.
+	BitBoolean BitEncoding = "boolean"
+	// BitHexString writes each value as a string of its hex digits, e.g. "0xFF", which avoids the loss of precision
+	// some consumers suffer parsing wide values as JSON numbers
+	BitHexString BitEncoding = "hexstring"
+)
+
+// WithBitEncoding sets how the values of BIT columns are encoded. The default is |BitInteger|, which is always used for
+// output configured with |WithSQLReplay|.
+func WithBitEncoding(enc BitEncoding) WriterOption {
+	return func(j *RowWriter) error {
+		if enc != BitInteger && enc != BitBoolean && enc != BitHexString {
+			return fmt.Errorf("unknown bit encoding '%s'", enc)
+		}
+		j.bitEncoding = enc
+		return nil
+	}
+}
+
+// WithIndent writes indented output for human review, with each row starting on a line of its own and each level of
+// nesting within it indented by |indent|, e.g. "  " or "\t". The separator between rows is adjusted to suit, and the
+// envelope's footer is written on its own line. Output is compact by default, or if |indent| is "". Line delimited
+// output can't be indented.
+func WithIndent(indent string) WriterOption {
+	return func(j *RowWriter) error {
+		if strings.Trim(indent, " \t") != "" {
+			return fmt.Errorf("indent must be spaces and tabs, got %q", indent)
+		}
+		j.indent = indent
+		return nil
+	}
+}
+
+// WithColumnProjection writes only the columns named, in the order given, so that a few columns of a wide table can be
+// exported without building a projected schema. Every column must exist in the schema, and any column read by another
+// option, such as a coalesce source or a profiled column, must be projected.
+func WithColumnProjection(cols []string) WriterOption {
+	return func(j *RowWriter) error {
+		if len(cols) == 0 {
+			return errors.New("column projection requires at least one column")
+		}
+
+		projected := make(map[string]struct{}, len(cols))
+		for _, name := range cols {
+			if _, ok := projected[name]; ok {
+				return fmt.Errorf("duplicate projected column '%s'", name)
+			}
+			projected[name] = struct{}{}
+		}
+
+		j.projection = cols
+		j.projected = projected
+		return nil
+	}
+}
+
+// WithFlushEvery flushes the writer automatically after every |n| rows written, bounding the rows buffered when the
+// destination is slow to accept them. Each automatic flush is the same as a call to Flush, so it ends a batch when
+// batch markers are enabled. An error flushing is returned from the write of the row that triggered the flush, which
+// has already been buffered. The default, 0, only flushes when asked.
+func WithFlushEvery(n int) WriterOption {
+	return func(j *RowWriter) error {
+		if n < 0 {
+			return fmt.Errorf("flush interval must not be negative, got %d", n)
+		}
+		j.flushEvery = n
+		return nil
+	}
+}
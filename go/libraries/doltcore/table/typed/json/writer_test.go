@@ -0,0 +1,1965 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/vitess/go/sqltypes"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/diff"
+	"github.com/dolthub/dolt/go/libraries/doltcore/row"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema/typeinfo"
+	"github.com/dolthub/dolt/go/libraries/utils/iohelp"
+	"github.com/dolthub/dolt/go/store/types"
+)
+
+func mustSchema(t testing.TB, cols ...schema.Column) schema.Schema {
+	sch, err := schema.SchemaFromCols(schema.NewColCollection(cols...))
+	require.NoError(t, err)
+	return sch
+}
+
+func writeSqlRows(t *testing.T, sch schema.Schema, rows []sql.Row, opts ...WriterOption) string {
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, opts...)
+	require.NoError(t, err)
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+	return buf.String()
+}
+
+func writeNomsRows(t *testing.T, sch schema.Schema, rows []row.TaggedValues, opts ...WriterOption) string {
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, opts...)
+	require.NoError(t, err)
+	for _, tv := range rows {
+		r, err := row.New(types.Format_Default, sch, tv)
+		require.NoError(t, err)
+		require.NoError(t, wr.WriteRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+	return buf.String()
+}
+
+func TestSQLReplayBooleans(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "flag", Tag: 1, Kind: types.BoolKind, TypeInfo: typeinfo.BoolType},
+	)
+
+	nomsRows := []row.TaggedValues{
+		{0: types.Int(1), 1: types.Bool(true)},
+		{0: types.Int(2), 1: types.Bool(false)},
+	}
+	sqlRows := []sql.Row{
+		{int64(1), true},
+		{int64(2), false},
+	}
+
+	expected := `{"rows": [{"id":1,"flag":1},{"id":2,"flag":0}]}`
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithSQLReplay(true)))
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithSQLReplay(true)))
+
+	assert.Equal(t, `{"rows": [{"id":1,"flag":true},{"id":2,"flag":false}]}`, writeNomsRows(t, sch, nomsRows))
+}
+
+func TestDocumentChecksum(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{
+		{int64(1), "checksum"},
+		{int64(2), `"checksum":"`},
+	}
+
+	for _, algo := range []ChecksumAlgorithm{ChecksumSHA256, ChecksumSHA1, ChecksumMD5} {
+		t.Run(string(algo), func(t *testing.T) {
+			doc := writeSqlRows(t, sch, rows, WithDocumentChecksum(algo))
+			assert.True(t, json.Valid([]byte(doc)), doc)
+			assert.Contains(t, doc, `],"checksum":"`+string(algo)+":")
+			assert.NoError(t, VerifyDocumentChecksum(strings.NewReader(doc)))
+
+			tampered := strings.Replace(doc, `"id":2`, `"id":3`, 1)
+			assert.Error(t, VerifyDocumentChecksum(strings.NewReader(tampered)))
+
+			// only the end of the envelope may follow the checksum
+			assert.NoError(t, VerifyDocumentChecksum(strings.NewReader(doc+"\n")))
+			for _, appended := range []string{`{"rows": [{"id":3}]}`, "\n\n", " ", "x"} {
+				assert.Error(t, VerifyDocumentChecksum(strings.NewReader(doc+appended)), appended)
+			}
+			assert.Error(t, VerifyDocumentChecksum(strings.NewReader(strings.TrimSuffix(doc, "}")+`,"rows2":[]}`)))
+		})
+	}
+
+	_, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&bytes.Buffer{}), sch, "[", "]", ",", WithDocumentChecksum(ChecksumSHA256))
+	assert.Error(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithDocumentChecksum("crc"))
+	assert.Error(t, err)
+}
+
+func TestCoalesce(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "mobile", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "home", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "age", Tag: 3, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{
+		{int64(1), "555-1234", "555-9999", int64(20)},
+		{int64(2), nil, "555-8888", nil},
+		{int64(3), nil, nil, int64(30)},
+	}
+
+	assert.Equal(t,
+		`{"rows": [{"id":1,"mobile":"555-1234","home":"555-9999","phone":"555-1234","age":20},{"id":2,"home":"555-8888","phone":"555-8888"},{"id":3,"age":30}]}`,
+		writeSqlRows(t, sch, rows, WithCoalesce("phone", []string{"mobile", "home"}, false)))
+	assert.Equal(t,
+		`{"rows": [{"id":1,"phone":"555-1234","age":20},{"id":2,"phone":"555-8888"},{"id":3,"age":30}]}`,
+		writeSqlRows(t, sch, rows, WithCoalesce("phone", []string{"mobile", "home"}, true)))
+
+	newWriter := func(opts ...WriterOption) error {
+		_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, opts...)
+		return err
+	}
+	assert.NoError(t, newWriter(WithCoalesce("mobile", []string{"mobile", "home"}, true)))
+	assert.Error(t, newWriter(WithCoalesce("mobile", []string{"home"}, false)))
+	assert.Error(t, newWriter(WithCoalesce("phone", []string{"mobile", "age"}, false)))
+	assert.Error(t, newWriter(WithCoalesce("phone", []string{"mobile", "fax"}, false)))
+	assert.Error(t, newWriter(WithCoalesce("phone", nil, false)))
+}
+
+func TestWriteLimiter(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}}
+
+	expected := `{"rows": [{"id":1},{"id":2}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, rows, WithWriteLimiter(rate.NewLimiter(rate.Inf, 0))))
+	assert.Equal(t, expected, writeSqlRows(t, sch, rows, WithWriteLimiter(rate.NewLimiter(rate.Limit(1e6), 4))))
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, WithWriteLimiter(rate.NewLimiter(rate.Limit(1), 1)))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, wr.WriteSqlRow(ctx, rows[0]))
+	cancel()
+	assert.ErrorIs(t, wr.Close(ctx), context.Canceled)
+	assert.Less(t, buf.Len(), len(expected))
+
+	// a flush between calls doesn't wait on the context of a call that has returned
+	buf.Reset()
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(&buf), sch, WithWriteLimiter(rate.NewLimiter(rate.Limit(1e6), 4)))
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	require.NoError(t, wr.WriteSqlRow(ctx, rows[0]))
+	cancel()
+	require.NoError(t, wr.Flush())
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `{"rows": [{"id":1}]}`, buf.String())
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithWriteLimiter(rate.NewLimiter(rate.Limit(1), 0)))
+	assert.Error(t, err)
+}
+
+func TestWriteCancelled(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	r, err := row.New(types.Format_Default, sch, row.TaggedValues{0: types.Int(1)})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, WithBufferSize(1))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, wr.WriteRow(ctx, r))
+	written := buf.String()
+	cancel()
+
+	assert.ErrorIs(t, wr.WriteRow(ctx, r), context.Canceled)
+	assert.ErrorIs(t, wr.WriteSqlRow(ctx, sql.Row{int64(2)}), context.Canceled)
+	assert.Equal(t, written, buf.String())
+	assert.Equal(t, 1, wr.rowsWritten)
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	r, err := row.New(types.Format_Default, sch, row.TaggedValues{0: types.Int(1)})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1)}))
+	require.NoError(t, wr.Close(context.Background()))
+	written := buf.String()
+
+	assert.EqualError(t, wr.WriteRow(context.Background(), r), "write on closed json writer")
+	assert.EqualError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(2)}), "write on closed json writer")
+	assert.EqualError(t, wr.WriteSqlRows(context.Background(), []sql.Row{{int64(2)}}), "write on closed json writer")
+	assert.EqualError(t, wr.Flush(), "write on closed json writer")
+	assert.EqualError(t, wr.Close(context.Background()), "already closed")
+	assert.Equal(t, written, buf.String())
+
+	buf.Reset()
+	wr, err = NewNDJSONWriter(iohelp.NopWrCloser(&buf), sch)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1)}))
+	require.NoError(t, wr.Close(context.Background()))
+	written = buf.String()
+
+	assert.EqualError(t, wr.UpdateSchema(sch), "write on closed json writer")
+	assert.Equal(t, written, buf.String())
+}
+
+func TestTimeFractionalSeconds(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "t", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.TimeType},
+	)
+	ts := []sql.Timespan{
+		sql.Time.MicrosecondsToTimespan(45296123456),   // 12:34:56.123456
+		sql.Time.MicrosecondsToTimespan(43200000000),   // 12:00:00
+		sql.Time.MicrosecondsToTimespan(-3723500000),   // -01:02:03.5
+		sql.Time.MicrosecondsToTimespan(3020398000001), // 838:59:58.000001
+	}
+
+	var sqlRows []sql.Row
+	var nomsRows []row.TaggedValues
+	for i, v := range ts {
+		sqlRows = append(sqlRows, sql.Row{int64(i), v})
+		nomsRows = append(nomsRows, row.TaggedValues{0: types.Int(i), 1: types.Int(v.AsMicroseconds())})
+	}
+
+	tests := []struct {
+		opts     []WriterOption
+		expected string
+	}{
+		{
+			expected: `{"rows": [{"id":0,"t":"12:34:56.123456"},{"id":1,"t":"12:00:00"},{"id":2,"t":"-01:02:03.500000"},{"id":3,"t":"838:59:58.000001"}]}`,
+		},
+		{
+			opts:     []WriterOption{WithTimeFractionalSeconds(6)},
+			expected: `{"rows": [{"id":0,"t":"12:34:56.123456"},{"id":1,"t":"12:00:00.000000"},{"id":2,"t":"-01:02:03.500000"},{"id":3,"t":"838:59:58.000001"}]}`,
+		},
+		{
+			opts:     []WriterOption{WithTimeFractionalSeconds(3)},
+			expected: `{"rows": [{"id":0,"t":"12:34:56.123"},{"id":1,"t":"12:00:00.000"},{"id":2,"t":"-01:02:03.500"},{"id":3,"t":"838:59:58.000"}]}`,
+		},
+		{
+			opts:     []WriterOption{WithTimeFractionalSeconds(0)},
+			expected: `{"rows": [{"id":0,"t":"12:34:56"},{"id":1,"t":"12:00:00"},{"id":2,"t":"-01:02:03"},{"id":3,"t":"838:59:58"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, writeSqlRows(t, sch, sqlRows, test.opts...))
+		assert.Equal(t, test.expected, writeNomsRows(t, sch, nomsRows, test.opts...))
+	}
+
+	// without the override every microsecond of a value is written, down to a single trailing microsecond
+	for micros, str := range map[int64]string{
+		1:            "00:00:00.000001",
+		999999:       "00:00:00.999999",
+		45296000001:  "12:34:56.000001",
+		-3723000001:  "-01:02:03.000001",
+		-45296123456: "-12:34:56.123456",
+	} {
+		expected := fmt.Sprintf(`{"rows": [{"id":0,"t":"%s"}]}`, str)
+		assert.Equal(t, expected, writeSqlRows(t, sch, []sql.Row{{int64(0), sql.Time.MicrosecondsToTimespan(micros)}}))
+		assert.Equal(t, expected, writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(0), 1: types.Int(micros)}}))
+
+		parsed, err := sql.Time.ConvertToTimespan(str)
+		require.NoError(t, err)
+		assert.Equal(t, micros, parsed.AsMicroseconds())
+	}
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithTimeFractionalSeconds(7))
+	assert.Error(t, err)
+}
+
+func TestLeadingRowCount(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}, {int64(3)}}
+
+	path := filepath.Join(t.TempDir(), "export.json")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	wr, err := NewJSONWriter(f, sch, WithLeadingRowCount(true))
+	require.NoError(t, err)
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"row_count":                   3, "rows": [{"id":1},{"id":2},{"id":3}]}`, string(data))
+
+	var doc struct {
+		RowCount int                      `json:"row_count"`
+		Rows     []map[string]interface{} `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, 3, doc.RowCount)
+	assert.Len(t, doc.Rows, 3)
+
+	// destinations that can't seek omit the count
+	assert.Equal(t, `{"rows": [{"id":1},{"id":2},{"id":3}]}`, writeSqlRows(t, sch, rows, WithLeadingRowCount(true)))
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithLeadingRowCount(true), WithDocumentChecksum(ChecksumSHA256))
+	assert.Error(t, err)
+}
+
+func TestLossinessWarnings(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "t", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.TimeType},
+	)
+	rows := []sql.Row{
+		{int64(0), sql.Time.MicrosecondsToTimespan(1500000)},
+		{int64(1), sql.Time.MicrosecondsToTimespan(1123456)},
+	}
+
+	assert.Equal(t,
+		`{"rows": [{"id":0,"t":"00:00:01.500"},{"id":1,"t":"00:00:01.123"}],"warnings":[{"row":1,"column":"t","reason":"fractional seconds truncated to 3 digits"}]}`,
+		writeSqlRows(t, sch, rows, WithTimeFractionalSeconds(3), WithLossinessWarnings(true)))
+	assert.Equal(t,
+		`{"rows": [{"id":0,"t":"00:00:01.500000"},{"id":1,"t":"00:00:01.123456"}],"warnings":[]}`,
+		writeSqlRows(t, sch, rows, WithLossinessWarnings(true)))
+
+	doc := writeSqlRows(t, sch, rows, WithTimeFractionalSeconds(0), WithLossinessWarnings(true), WithDocumentChecksum(ChecksumSHA256))
+	assert.True(t, json.Valid([]byte(doc)), doc)
+	assert.NoError(t, VerifyDocumentChecksum(strings.NewReader(doc)))
+	assert.Contains(t, doc, `"warnings":[{"row":0,`)
+
+	binType, err := typeinfo.FromSqlType(sql.MustCreateBinary(sqltypes.VarBinary, 16))
+	require.NoError(t, err)
+	sch = mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "dt", Tag: 1, Kind: types.TimestampKind, TypeInfo: typeinfo.DatetimeType},
+		schema.Column{Name: "bin", Tag: 2, Kind: types.InlineBlobKind, TypeInfo: binType},
+		schema.Column{Name: "score", Tag: 3, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+	)
+	rows = []sql.Row{
+		{int64(0), time.Date(2024, 1, 15, 3, 4, 5, 0, time.UTC), []byte("ok"), 1.5},
+		{int64(1), time.Date(2024, 1, 15, 3, 4, 5, 250000000, time.UTC), []byte{0xff, 'a'}, math.NaN()},
+		{int64(2), time.Date(2024, 1, 15, 3, 4, 5, 250000000, time.UTC), nil, nil},
+	}
+	warnings := func(t *testing.T, doc string) []lossinessWarning {
+		var parsed struct {
+			Warnings []lossinessWarning `json:"warnings"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(doc), &parsed), doc)
+		return parsed.Warnings
+	}
+
+	doc = writeSqlRows(t, sch, rows, WithLossinessWarnings(true), WithDatetimeLayout(time.RFC3339, nil), WithNonFiniteFloats(NonFiniteAsNull))
+	assert.Equal(t, []lossinessWarning{
+		{Row: 1, Column: "dt", Reason: "fractional seconds truncated to 0 digits by the datetime layout"},
+		{Row: 1, Column: "bin", Reason: "invalid UTF-8 replaced"},
+		{Row: 1, Column: "score", Reason: "NaN written as null"},
+		{Row: 2, Column: "dt", Reason: "fractional seconds truncated to 0 digits by the datetime layout"},
+	}, warnings(t, doc))
+
+	// a layout that writes the fraction, base64 binary and strings for non-finite floats are loss-free
+	doc = writeSqlRows(t, sch, rows, WithLossinessWarnings(true), WithDatetimeLayout("2006-01-02T15:04:05.000Z07:00", nil),
+		WithBinaryEncoding(BinaryBase64), WithNonFiniteFloats(NonFiniteAsString))
+	assert.Empty(t, warnings(t, doc))
+
+	assert.Equal(t, 0, layoutFracDigits(time.RFC3339))
+	assert.Equal(t, 9, layoutFracDigits(time.RFC3339Nano))
+	assert.Equal(t, 3, layoutFracDigits("15:04:05,000"))
+	assert.Equal(t, 0, layoutFracDigits("15:04:05.0001"))
+}
+
+func TestNestedKeyTransform(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "ID", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "Doc", Tag: 1, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+	doc := sql.MustJSON(`{"Name": "a", "Items": [{"Qty": 1}, [{"Sku": "x"}]], "Meta": {"Tag": null}}`)
+
+	vrw := types.NewMemoryValueStore()
+	nomsDoc, err := typeinfo.JSONType.ConvertValueToNomsValue(context.Background(), vrw, doc)
+	require.NoError(t, err)
+
+	nested := `{"rows": [{"ID":1,"Doc":{"Items":[{"Qty":1},[{"Sku":"x"}]],"Meta":{"Tag":null},"Name":"a"}}]}`
+	assert.Equal(t, nested, writeSqlRows(t, sch, []sql.Row{{int64(1), doc}}))
+	assert.Equal(t, nested, writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(1), 1: nomsDoc}}))
+
+	lower := WithKeyNameFunc(strings.ToLower)
+	assert.Equal(t,
+		`{"rows": [{"id":1,"doc":{"Items":[{"Qty":1},[{"Sku":"x"}]],"Meta":{"Tag":null},"Name":"a"}}]}`,
+		writeSqlRows(t, sch, []sql.Row{{int64(1), doc}}, lower))
+
+	transformed := `{"rows": [{"id":1,"doc":{"items":[{"qty":1},[{"sku":"x"}]],"meta":{"tag":null},"name":"a"}}]}`
+	assert.Equal(t, transformed, writeSqlRows(t, sch, []sql.Row{{int64(1), doc}}, lower, WithNestedKeyTransform(true)))
+	assert.Equal(t, transformed, writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(1), 1: nomsDoc}}, lower, WithNestedKeyTransform(true)))
+
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, lower, WithNestedKeyTransform(true))
+	require.NoError(t, err)
+	assert.EqualError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1), sql.MustJSON(`{"a": 1, "A": 2}`)}),
+		"keys 'A' and 'a' are both transformed to the name 'a'")
+}
+
+func TestGlobalSequence(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(7)}, {int64(8)}}
+
+	assert.Equal(t, `{"rows": [{"id":7,"_seq":1000},{"id":8,"_seq":1001}]}`, writeSqlRows(t, sch, rows, WithGlobalSequence(1000)))
+	// a second run continues from the high-water mark of the first
+	assert.Equal(t, `{"rows": [{"id":7,"_seq":1002},{"id":8,"_seq":1003}]}`, writeSqlRows(t, sch, rows, WithGlobalSequence(1002)))
+
+	seqSch := mustSchema(t,
+		schema.Column{Name: "_seq", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), seqSch, WithGlobalSequence(0))
+	require.NoError(t, err)
+	assert.Error(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1)}))
+}
+
+func TestUpdateSchema(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	newSch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, "", "", "\n")
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(1)}))
+	require.NoError(t, wr.UpdateSchema(newSch))
+	assert.Equal(t, newSch, wr.GetSchema())
+	require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(2), "two"}))
+	require.NoError(t, wr.Close(context.Background()))
+
+	expected := `{"id":1}
+{"schema_change":{"columns":[{"name":"id","type":"bigint","primary_key":true},{"name":"name","type":"varchar(16383)","primary_key":false}]}}
+{"id":2,"name":"two"}`
+	assert.Equal(t, expected, buf.String())
+
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch)
+	require.NoError(t, err)
+	assert.Error(t, wr.UpdateSchema(newSch))
+
+	// the new schema is checked against the writer's options, and nothing is written for one that fails
+	keylessSch := mustSchema(t,
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	conflictSch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "id.name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	tests := []struct {
+		name    string
+		opt     WriterOption
+		initSch schema.Schema
+		newSch  schema.Schema
+	}{
+		{"surrogate id", WithSurrogateID("sid"), keylessSch, newSch},
+		{"nested column paths", WithNestedColumnPaths("."), sch, conflictSch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			wr, err := NewNDJSONWriter(iohelp.NopWrCloser(&buf), test.initSch, test.opt)
+			require.NoError(t, err)
+			assert.Error(t, wr.UpdateSchema(test.newSch))
+			assert.Equal(t, test.initSch, wr.GetSchema())
+			require.NoError(t, wr.Close(context.Background()))
+			assert.Empty(t, buf.String())
+		})
+	}
+}
+
+func TestDecimalVerbose(t *testing.T) {
+	decType, err := typeinfo.FromSqlType(sql.MustCreateDecimalType(10, 2))
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "price", Tag: 1, Kind: types.DecimalKind, TypeInfo: decType},
+	)
+
+	price := decimal.RequireFromString("123.45")
+	sqlRows := []sql.Row{{int64(1), price}, {int64(2), nil}}
+	nomsRows := []row.TaggedValues{{0: types.Int(1), 1: types.Decimal(price)}, {0: types.Int(2)}}
+
+	assert.Equal(t, `{"rows": [{"id":1,"price":"123.45"},{"id":2}]}`, writeSqlRows(t, sch, sqlRows))
+
+	expected := `{"rows": [{"id":1,"price":{"value":"123.45","precision":10,"scale":2}},{"id":2}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithDecimalVerbose(true)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithDecimalVerbose(true)))
+}
+
+func TestLineEnding(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}}
+
+	writeLines := func(opts ...WriterOption) string {
+		var buf bytes.Buffer
+		wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, "", "", "\n", opts...)
+		require.NoError(t, err)
+		for _, r := range rows {
+			require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+		}
+		require.NoError(t, wr.Close(context.Background()))
+		return buf.String()
+	}
+
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}", writeLines())
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}", writeLines(WithLineEnding(LF)))
+	assert.Equal(t, "{\"id\":1}\r\n{\"id\":2}", writeLines(WithLineEnding(CRLF)))
+
+	// array output is unaffected
+	assert.Equal(t, `{"rows": [{"id":1},{"id":2}]}`, writeSqlRows(t, sch, rows, WithLineEnding(CRLF)))
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithLineEnding("\r"))
+	assert.Error(t, err)
+}
+
+func TestBatchMarkers(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, "", "", "\n", WithBatchMarkers(true))
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(1)}))
+	require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(2)}))
+	require.NoError(t, wr.Flush())
+	require.NoError(t, wr.Flush())
+	require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(3)}))
+	require.NoError(t, wr.Close(ctx))
+
+	assert.Equal(t, "{\"id\":1}\n{\"id\":2}\n{\"_batch_end\":true}\n{\"id\":3}\n{\"_batch_end\":true}", buf.String())
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBatchMarkers(true))
+	assert.Error(t, err)
+}
+
+func TestHashBucketing(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+
+	const n = 3
+	var rows []sql.Row
+	expected := make([][]string, n)
+	for i := int64(0); i < 10; i++ {
+		rows = append(rows, sql.Row{i, fmt.Sprintf("row%d", i)})
+
+		h := fnv.New64a()
+		h.Write([]byte(fmt.Sprintf("[%d]", i)))
+		b := h.Sum64() % n
+		expected[b] = append(expected[b], fmt.Sprintf(`{"id":%d,"name":"row%d"}`, i, i))
+	}
+
+	var want strings.Builder
+	want.WriteString(`{"buckets": {`)
+	for i, bucket := range expected {
+		if i > 0 {
+			want.WriteString(",")
+		}
+		want.WriteString(fmt.Sprintf(`"%d": [%s]`, i, strings.Join(bucket, ",")))
+	}
+	want.WriteString("}}")
+
+	out := writeSqlRows(t, sch, rows, WithHashBucketing(n))
+	assert.Equal(t, want.String(), out)
+	assert.True(t, json.Valid([]byte(out)))
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithHashBucketing(0))
+	assert.Error(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithHashBucketing(n), WithDocumentChecksum(ChecksumSHA256))
+	assert.Error(t, err)
+
+	keyless := mustSchema(t, schema.Column{Name: "v", Tag: 0, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type})
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), keyless, WithHashBucketing(n))
+	assert.Error(t, err)
+}
+
+func TestTypeTagsForAmbiguous(t *testing.T) {
+	decType, err := typeinfo.FromSqlType(sql.MustCreateDecimalType(10, 2))
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "day", Tag: 2, Kind: types.TimestampKind, TypeInfo: typeinfo.DateType},
+		schema.Column{Name: "price", Tag: 3, Kind: types.DecimalKind, TypeInfo: decType},
+	)
+
+	day := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+	price := decimal.RequireFromString("123.45")
+	sqlRows := []sql.Row{{int64(1), "2022-03-04", day, price}, {int64(2), nil, nil, nil}}
+	nomsRows := []row.TaggedValues{
+		{0: types.Int(1), 1: types.String("2022-03-04"), 2: types.Timestamp(day), 3: types.Decimal(price)},
+		{0: types.Int(2)},
+	}
+
+	expected := `{"rows": [{"id":1,"name":"2022-03-04","day":{"type":"date","value":"2022-03-04"},"price":{"type":"decimal","value":"123.45"}},{"id":2}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithTypeTagsForAmbiguous(true)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithTypeTagsForAmbiguous(true)))
+
+	// verbose decimals already carry their type
+	expected = `{"rows": [{"id":1,"name":"2022-03-04","day":{"type":"date","value":"2022-03-04"},"price":{"value":"123.45","precision":10,"scale":2}},{"id":2}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithTypeTagsForAmbiguous(true), WithDecimalVerbose(true)))
+}
+
+func TestWriteRowWithSchema(t *testing.T) {
+	users := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	orders := mustSchema(t,
+		schema.Column{Name: "order_id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "total", Tag: 1, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+	)
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), users, "", "", "\n")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, wr.WriteRowWithSchema(ctx, sql.Row{int64(1), "ann"}, users, "user"))
+	require.NoError(t, wr.WriteRowWithSchema(ctx, sql.Row{int64(7), 9.5}, orders, "order"))
+	assert.Error(t, wr.WriteSqlRow(ctx, sql.Row{int64(2), "bob"}))
+	require.NoError(t, wr.Close(ctx))
+
+	assert.Equal(t, "{\"_type\":\"user\",\"id\":1,\"name\":\"ann\"}\n{\"_type\":\"order\",\"order_id\":7,\"total\":9.5}", buf.String())
+
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), users, WithDocumentChecksum(ChecksumSHA256))
+	require.NoError(t, err)
+	assert.Error(t, wr.WriteRowWithSchema(ctx, sql.Row{int64(1), "ann"}, users, "user"))
+
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), users)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(ctx, sql.Row{int64(1), "ann"}))
+	assert.Error(t, wr.WriteRowWithSchema(ctx, sql.Row{int64(7), 9.5}, orders, "order"))
+}
+
+func TestNormalizeJSONColumns(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "doc", Tag: 1, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+
+	stored := `{ "b": [1.50, 15e-1, 12345678901234567890],
+		"a": {"y": 1, "x": "two"} }`
+	expected := `{"rows": [{"id":1,"doc":{"a":{"x":"two","y":1},"b":[1.5,1.5,12345678901234567890]}}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, []sql.Row{{int64(1), stored}}, WithNormalizeJSONColumns(true)))
+
+	nomsDoc, err := typeinfo.JSONType.ConvertValueToNomsValue(context.Background(), types.NewMemoryValueStore(), sql.MustJSON(`{"b": 1.50, "a": []}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"rows": [{"id":1,"doc":{"a":[],"b":1.5}}]}`,
+		writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(1), 1: nomsDoc}}, WithNormalizeJSONColumns(true)))
+
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithNormalizeJSONColumns(true))
+	require.NoError(t, err)
+	err = wr.WriteSqlRow(context.Background(), sql.Row{int64(1), `{"a": 1`})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "column 'doc'")
+}
+
+func TestColumnProfile(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "status", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "qty", Tag: 2, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{
+		{int64(1), "active", int64(5)},
+		{int64(2), "closed", int64(5)},
+		{int64(3), "active", nil},
+	}
+
+	assert.Equal(t,
+		`{"rows": [{"id":1,"status":"active","qty":5},{"id":2,"status":"closed","qty":5},{"id":3,"status":"active"}],`+
+			`"profile":{"qty":{"count":2,"values":{"5":2}},"status":{"count":3,"values":{"active":2,"closed":1}}}}`,
+		writeSqlRows(t, sch, rows, WithColumnProfile([]string{"status", "qty"})))
+
+	rows = nil
+	for i := 0; i <= maxProfileValues; i++ {
+		rows = append(rows, sql.Row{int64(i), "active", int64(i)})
+	}
+	doc := writeSqlRows(t, sch, rows, WithColumnProfile([]string{"status", "qty"}))
+	assert.True(t, strings.HasSuffix(doc, `"profile":{"qty":{"count":101,"high_cardinality":true},"status":{"count":101,"values":{"active":101}}}}`), doc)
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithColumnProfile([]string{"missing"}))
+	assert.Error(t, err)
+	_, err = NewJSONWriterWithHeader(iohelp.NopWrCloser(&bytes.Buffer{}), sch, "", "", "\n", WithColumnProfile([]string{"status"}))
+	assert.Error(t, err)
+}
+
+func TestFieldOmitPredicate(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "qty", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "tags", Tag: 2, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+
+	omitEmpty := WithFieldOmitPredicate(func(col schema.Column, val interface{}) bool {
+		if col.IsPartOfPK {
+			return false
+		}
+		switch v := val.(type) {
+		case int64:
+			return v == 0
+		case []interface{}:
+			return len(v) == 0
+		}
+		return false
+	})
+
+	sqlRows := []sql.Row{
+		{int64(0), int64(0), sql.MustJSON(`[]`)},
+		{int64(1), int64(3), sql.MustJSON(`["a"]`)},
+	}
+	expected := `{"rows": [{"id":0},{"id":1,"qty":3,"tags":["a"]}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, omitEmpty))
+
+	vrw := types.NewMemoryValueStore()
+	var nomsRows []row.TaggedValues
+	for _, r := range sqlRows {
+		tags, err := typeinfo.JSONType.ConvertValueToNomsValue(context.Background(), vrw, r[2])
+		require.NoError(t, err)
+		nomsRows = append(nomsRows, row.TaggedValues{0: types.Int(r[0].(int64)), 1: types.Int(r[1].(int64)), 2: tags})
+	}
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, omitEmpty))
+
+	// the predicate is given the same values whichever way a row is written
+	var sqlVals, nomsVals []interface{}
+	record := func(vals *[]interface{}) WriterOption {
+		return WithFieldOmitPredicate(func(col schema.Column, val interface{}) bool {
+			*vals = append(*vals, val)
+			return false
+		})
+	}
+	writeSqlRows(t, sch, sqlRows, record(&sqlVals))
+	writeNomsRows(t, sch, nomsRows, record(&nomsVals))
+	assert.Equal(t, sqlVals, nomsVals)
+}
+
+func TestHashChain(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{{int64(1), "ann"}, {int64(2), "bob"}, {int64(3), "cat"}}
+
+	doc := writeSqlRows(t, sch, rows, WithHashChain(true))
+	first := fmt.Sprintf(`{"id":1,"name":"ann","_prev_hash":"%s"}`, DefaultHashChainGenesis)
+	second := fmt.Sprintf(`{"id":2,"name":"bob","_prev_hash":"%s"}`, rowHash([]byte(first)))
+	assert.True(t, strings.HasPrefix(doc, `{"rows": [`+first+","+second+","), doc)
+	assert.NoError(t, VerifyHashChain(strings.NewReader(doc), DefaultHashChainGenesis))
+
+	tampered := strings.Replace(doc, "bob", "eve", 1)
+	err := VerifyHashChain(strings.NewReader(tampered), DefaultHashChainGenesis)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2")
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, "", "", "\n",
+		WithHashChain(true), WithHashChainGenesis("abc"), WithBatchMarkers(true))
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(ctx, r))
+		require.NoError(t, wr.Flush())
+	}
+	require.NoError(t, wr.Close(ctx))
+	assert.NoError(t, VerifyHashChain(bytes.NewReader(buf.Bytes()), "abc"))
+	assert.Error(t, VerifyHashChain(bytes.NewReader(buf.Bytes()), DefaultHashChainGenesis))
+
+	buf.Reset()
+	wr, err = NewJSONWriterWithKey(iohelp.NopWrCloser(&buf), sch, "data", WithHashChain(true))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(ctx, rows))
+	require.NoError(t, wr.Close(ctx))
+	assert.NoError(t, VerifyHashChainWithKey(bytes.NewReader(buf.Bytes()), DefaultHashChainGenesis, "data"))
+	tampered = strings.Replace(buf.String(), "bob", "eve", 1)
+	err = VerifyHashChainWithKey(strings.NewReader(tampered), DefaultHashChainGenesis, "data")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2")
+}
+
+func TestAllValuesAsStringsNull(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "qty", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "flag", Tag: 2, Kind: types.BoolKind, TypeInfo: typeinfo.BoolType},
+	)
+	sqlRows := []sql.Row{{int64(1), int64(5), true}, {int64(2), nil, nil}}
+	nomsRows := []row.TaggedValues{{0: types.Int(1), 1: types.Int(5), 2: types.Bool(true)}, {0: types.Int(2)}}
+
+	tests := []struct {
+		name     string
+		opts     []WriterOption
+		expected string
+	}{
+		{
+			name:     "omitted",
+			opts:     []WriterOption{WithAllValuesAsStrings(true)},
+			expected: `{"rows": [{"id":"1","qty":"5","flag":"true"},{"id":"2"}]}`,
+		},
+		{
+			name:     "json null",
+			opts:     []WriterOption{WithAllValuesAsStrings(true), WithAllStringsNull(NullAsJSONNull, "")},
+			expected: `{"rows": [{"id":"1","qty":"5","flag":"true"},{"id":"2","qty":null,"flag":null}]}`,
+		},
+		{
+			name:     "token",
+			opts:     []WriterOption{WithAllValuesAsStrings(true), WithAllStringsNull(NullAsToken, "NULL")},
+			expected: `{"rows": [{"id":"1","qty":"5","flag":"true"},{"id":"2","qty":"NULL","flag":"NULL"}]}`,
+		},
+		{
+			name:     "empty token",
+			opts:     []WriterOption{WithAllValuesAsStrings(true), WithAllStringsNull(NullAsToken, "")},
+			expected: `{"rows": [{"id":"1","qty":"5","flag":"true"},{"id":"2","qty":"","flag":""}]}`,
+		},
+		{
+			name:     "not all strings",
+			opts:     []WriterOption{WithAllStringsNull(NullAsToken, "NULL")},
+			expected: `{"rows": [{"id":1,"qty":5,"flag":true},{"id":2}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, writeSqlRows(t, sch, sqlRows, test.opts...))
+			assert.Equal(t, test.expected, writeNomsRows(t, sch, nomsRows, test.opts...))
+		})
+	}
+}
+
+func TestSurrogateID(t *testing.T) {
+	keyless := mustSchema(t, schema.Column{Name: "v", Tag: 0, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType})
+	rows := []sql.Row{{"a"}, {"b"}}
+
+	assert.Equal(t, `{"rows": [{"v":"a","row_id":1},{"v":"b","row_id":2}]}`, writeSqlRows(t, keyless, rows, WithSurrogateID("row_id")))
+	assert.Equal(t, `{"rows": [{"v":"a","_seq":40,"row_id":40},{"v":"b","_seq":41,"row_id":41}]}`,
+		writeSqlRows(t, keyless, rows, WithSurrogateID("row_id"), WithGlobalSequence(40)))
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), keyless, WithSurrogateID("v"))
+	assert.Error(t, err)
+
+	keyed := mustSchema(t, schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type})
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), keyed, WithSurrogateID("row_id"))
+	assert.Error(t, err)
+}
+
+func TestEmptyResult(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+
+	assert.Equal(t, `{"rows": []}`, writeSqlRows(t, sch, nil))
+	assert.Equal(t, `{"rows": [],"warnings":[]}`, writeSqlRows(t, sch, nil, WithLossinessWarnings(true)))
+	assert.Equal(t, `{"buckets": {"0": [],"1": []}}`, writeSqlRows(t, sch, nil, WithHashBucketing(2)))
+
+	doc := writeSqlRows(t, sch, nil, WithDocumentChecksum(ChecksumSHA256))
+	assert.True(t, json.Valid([]byte(doc)), doc)
+	assert.NoError(t, VerifyDocumentChecksum(strings.NewReader(doc)))
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, `{"data": [`, `], "more": false}`, ",")
+	require.NoError(t, err)
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `{"data": [], "more": false}`, buf.String())
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+
+	write := func(rows []sql.Row, opts ...WriterOption) string {
+		var buf bytes.Buffer
+		wr, err := NewNDJSONWriter(iohelp.NopWrCloser(&buf), sch, opts...)
+		require.NoError(t, err)
+		for _, r := range rows {
+			require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+		}
+		require.NoError(t, wr.Close(context.Background()))
+		return buf.String()
+	}
+
+	rows := []sql.Row{{int64(1), "ann"}, {int64(2), nil}}
+	assert.Equal(t, "{\"id\":1,\"name\":\"ann\"}\n{\"id\":2}\n", write(rows))
+	assert.Equal(t, "{\"id\":1,\"name\":\"ann\"}\r\n{\"id\":2}\r\n", write(rows, WithLineEnding(CRLF)))
+	assert.Equal(t, "{\"id\":1,\"name\":\"ann\"}\n{\"id\":2}\n{\"_batch_end\":true}\n", write(rows, WithBatchMarkers(true)))
+	assert.Equal(t, "", write(nil))
+}
+
+func TestJSONColumns(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "doc", Tag: 1, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+
+	docs := []interface{}{`{"a":1,"b":{"c":[true,null]}}`, `[1,"two"]`, `"str"`, `null`, nil}
+	expected := `{"rows": [{"id":0,"doc":{"a":1,"b":{"c":[true,null]}}},{"id":1,"doc":[1,"two"]},{"id":2,"doc":"str"},{"id":3,"doc":null},{"id":4}]}`
+
+	vrw := types.NewMemoryValueStore()
+	var sqlRows []sql.Row
+	var nomsRows []row.TaggedValues
+	for i, doc := range docs {
+		sqlRows = append(sqlRows, sql.Row{int64(i), doc})
+
+		tv := row.TaggedValues{0: types.Int(i)}
+		if doc != nil {
+			nomsDoc, err := typeinfo.JSONType.ConvertValueToNomsValue(context.Background(), vrw, sql.MustJSON(doc.(string)))
+			require.NoError(t, err)
+			tv[1] = nomsDoc
+		}
+		nomsRows = append(nomsRows, tv)
+	}
+
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows))
+}
+
+func TestIncludeNulls(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "doc", Tag: 2, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+	sqlRows := []sql.Row{{int64(1), "ann", `{"a":1}`}, {int64(2), nil, nil}}
+	nomsRows := []row.TaggedValues{{0: types.Int(2)}}
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithOptions(iohelp.NopWrCloser(&buf), sch, JSONWriterOptions{IncludeNulls: true})
+	require.NoError(t, err)
+	for _, r := range sqlRows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `{"rows": [{"id":1,"name":"ann","doc":{"a":1}},{"id":2,"name":null,"doc":null}]}`, buf.String())
+
+	assert.Equal(t, `{"rows": [{"id":2,"name":null,"doc":null}]}`, writeNomsRows(t, sch, nomsRows, WithIncludeNulls(true)))
+	assert.Equal(t, `{"rows": [{"id":2,"name":"","doc":null}]}`, writeNomsRows(t, sch, nomsRows, WithIncludeNulls(true), WithNullStringsAsEmpty(true)))
+	assert.Equal(t, `{"rows": [{"id":2}]}`, writeNomsRows(t, sch, nomsRows))
+}
+
+func TestMarshalError(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "score", Tag: 1, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+	)
+
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch)
+	require.NoError(t, err)
+	err = wr.WriteSqlRow(context.Background(), sql.Row{make(chan int), 1.5})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'id'")
+	var unsupported *json.UnsupportedTypeError
+	assert.True(t, errors.As(err, &unsupported))
+
+	r, err := row.New(types.Format_Default, sch, row.TaggedValues{0: types.Int(1), 1: types.Float(math.NaN())})
+	require.NoError(t, err)
+	err = wr.WriteRow(context.Background(), r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'score'")
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "score", Tag: 1, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+	)
+	vals := []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1.5}
+
+	var sqlRows []sql.Row
+	var nomsRows []row.TaggedValues
+	for i, f := range vals {
+		sqlRows = append(sqlRows, sql.Row{int64(i), f})
+		nomsRows = append(nomsRows, row.TaggedValues{0: types.Int(i), 1: types.Float(f)})
+	}
+
+	expected := `{"rows": [{"id":0,"score":null},{"id":1,"score":null},{"id":2,"score":null},{"id":3,"score":1.5}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithNonFiniteFloats(NonFiniteAsNull)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithNonFiniteFloats(NonFiniteAsNull)))
+
+	expected = `{"rows": [{"id":0,"score":"NaN"},{"id":1,"score":"Infinity"},{"id":2,"score":"-Infinity"},{"id":3,"score":1.5}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithNonFiniteFloats(NonFiniteAsString)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithNonFiniteFloats(NonFiniteAsString)))
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), sqlRows[3]))
+	err = wr.WriteSqlRow(context.Background(), sqlRows[1])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'score'")
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `{"rows": [{"id":3,"score":1.5}]}`, buf.String())
+}
+
+func TestJSONWriterWithKey(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+
+	write := func(key string, rows ...sql.Row) string {
+		var buf bytes.Buffer
+		wr, err := NewJSONWriterWithKey(iohelp.NopWrCloser(&buf), sch, key)
+		require.NoError(t, err)
+		for _, r := range rows {
+			require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+		}
+		require.NoError(t, wr.Close(context.Background()))
+		return buf.String()
+	}
+
+	assert.Equal(t, `{"data": [{"id":1},{"id":2}]}`, write("data", sql.Row{int64(1)}, sql.Row{int64(2)}))
+	assert.Equal(t, `{"data": []}`, write("data"))
+
+	doc := write(`we"ird\key`, sql.Row{int64(1)})
+	assert.Equal(t, `{"we\"ird\\key": [{"id":1}]}`, doc)
+	var decoded map[string][]map[string]int
+	require.NoError(t, json.Unmarshal([]byte(doc), &decoded))
+	assert.Equal(t, []map[string]int{{"id": 1}}, decoded[`we"ird\key`])
+
+	_, err := NewJSONWriterWithKey(iohelp.NopWrCloser(&bytes.Buffer{}), sch, "")
+	assert.Error(t, err)
+	_, err = NewJSONWriterWithKey(iohelp.NopWrCloser(&bytes.Buffer{}), sch, "\xff")
+	assert.Error(t, err)
+}
+
+func TestYearPathsAgree(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "year", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.YearType},
+	)
+
+	sqlYear, err := typeinfo.YearType.ToSqlType().Convert(2021)
+	require.NoError(t, err)
+	nomsYear, err := typeinfo.YearType.ConvertValueToNomsValue(context.Background(), nil, sqlYear)
+	require.NoError(t, err)
+
+	expected := `{"rows": [{"id":1,"year":2021}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, []sql.Row{{int64(1), sqlYear}}))
+	assert.Equal(t, expected, writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(1), 1: nomsYear}}))
+}
+
+func TestDecimalsAsNumbers(t *testing.T) {
+	decType, err := typeinfo.FromSqlType(sql.MustCreateDecimalType(65, 30))
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.UintKind, IsPartOfPK: true, TypeInfo: typeinfo.Uint64Type},
+		schema.Column{Name: "price", Tag: 1, Kind: types.DecimalKind, TypeInfo: decType},
+	)
+
+	price := decimal.RequireFromString("12345678901234567890.123456789012345678901234567890")
+	sqlRows := []sql.Row{{uint64(math.MaxUint64), price}}
+	nomsRows := []row.TaggedValues{{0: types.Uint(math.MaxUint64), 1: types.Decimal(price)}}
+
+	expected := `{"rows": [{"id":18446744073709551615,"price":12345678901234567890.123456789012345678901234567890}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithDecimalsAsNumbers(true)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithDecimalsAsNumbers(true)))
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithDecimalsAsNumbers(true), WithTypeTagsForAmbiguous(true)))
+
+	assert.Equal(t, `{"rows": [{"id":18446744073709551615,"price":"12345678901234567890.123456789012345678901234567890"}]}`,
+		writeSqlRows(t, sch, sqlRows))
+}
+
+func TestEscapeHTML(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "html", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "doc", Tag: 2, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+	rows := []sql.Row{{int64(1), `<b>Tom & Jerry</b>`, `{"a":"<i>"}`}}
+
+	assert.Equal(t, `{"rows": [{"id":1,"html":"\u003cb\u003eTom \u0026 Jerry\u003c/b\u003e","doc":{"a":"\u003ci\u003e"}}]}`,
+		writeSqlRows(t, sch, rows))
+	assert.Equal(t, `{"rows": [{"id":1,"html":"<b>Tom & Jerry</b>","doc":{"a":"<i>"}}]}`,
+		writeSqlRows(t, sch, rows, WithEscapeHTML(false)))
+	assert.Equal(t, `{"rows": [{"id":1,"html":"<b>Tom & Jerry</b>","doc":{"a":"<i>"}}],"profile":{"html":{"count":1,"values":{"<b>Tom & Jerry</b>":1}}}}`,
+		writeSqlRows(t, sch, rows, WithEscapeHTML(false), WithColumnProfile([]string{"html"})))
+}
+
+func TestBufferSize(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch)
+	require.NoError(t, err)
+	assert.Equal(t, WriteBufSize, wr.bWr.Size())
+
+	var buf bytes.Buffer
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(&buf), sch, WithBufferSize(16))
+	require.NoError(t, err)
+	assert.Equal(t, 16, wr.bWr.Size())
+	for i := 0; i < 3; i++ {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), sql.Row{int64(i)}))
+	}
+	// rows reach the destination once the small buffer fills, before any flush
+	assert.NotEmpty(t, buf.String())
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `{"rows": [{"id":0},{"id":1},{"id":2}]}`, buf.String())
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBufferSize(0))
+	assert.Error(t, err)
+}
+
+func TestWriteSqlRows(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "mobile", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "home", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "age", Tag: 3, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{
+		{int64(1), "555-1234", "555-9999", int64(20)},
+		{int64(2), nil, "555-8888", nil},
+		{int64(3), nil, nil, int64(30)},
+	}
+
+	writeBatch := func(opts ...WriterOption) string {
+		var buf bytes.Buffer
+		wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch, opts...)
+		require.NoError(t, err)
+		require.NoError(t, wr.WriteSqlRows(context.Background(), rows[:1]))
+		require.NoError(t, wr.WriteSqlRows(context.Background(), rows[1:]))
+		require.NoError(t, wr.Close(context.Background()))
+		return buf.String()
+	}
+
+	for _, opts := range [][]WriterOption{
+		nil,
+		{WithCoalesce("phone", []string{"mobile", "home"}, true)},
+		{WithKeyNameFunc(strings.ToUpper), WithIncludeNulls(true)},
+		{WithGlobalSequence(10), WithHashChain(true)},
+	} {
+		assert.Equal(t, writeSqlRows(t, sch, rows, opts...), writeBatch(opts...))
+	}
+
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, wr.WriteSqlRows(ctx, rows), context.Canceled)
+	assert.Equal(t, 0, wr.rowsWritten)
+}
+
+func benchmarkSchema(b *testing.B) (schema.Schema, []sql.Row) {
+	sch := mustSchema(b,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "score", Tag: 2, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+		schema.Column{Name: "age", Tag: 3, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+	)
+
+	rows := make([]sql.Row, 1000)
+	for i := range rows {
+		rows[i] = sql.Row{int64(i), fmt.Sprintf("name%d", i), float64(i) / 3, int64(i % 100)}
+	}
+	return sch, rows
+}
+
+func BenchmarkWriteSqlRow(b *testing.B) {
+	sch, rows := benchmarkSchema(b)
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(io.Discard), sch)
+	require.NoError(b, err)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range rows {
+			if err := wr.WriteSqlRow(ctx, r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWriteSqlRows(b *testing.B) {
+	sch, rows := benchmarkSchema(b)
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(io.Discard), sch)
+	require.NoError(b, err)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := wr.WriteSqlRows(ctx, rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEnumsAndSets(t *testing.T) {
+	enumType, err := typeinfo.FromSqlType(sql.MustCreateEnumType([]string{"small", "medium", "large"}, sql.Collation_Default))
+	require.NoError(t, err)
+	setType, err := typeinfo.FromSqlType(sql.MustCreateSetType([]string{"a", "b", "c"}, sql.Collation_Default))
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "size", Tag: 1, Kind: types.UintKind, TypeInfo: enumType},
+		schema.Column{Name: "tags", Tag: 2, Kind: types.UintKind, TypeInfo: setType},
+	)
+	sqlRows := []sql.Row{{int64(1), uint16(2), uint64(5)}, {int64(2), "large", "b"}, {int64(3), uint16(1), uint64(0)}}
+	nomsRows := []row.TaggedValues{
+		{0: types.Int(1), 1: types.Uint(2), 2: types.Uint(5)},
+		{0: types.Int(2), 1: types.Uint(3), 2: types.Uint(2)},
+		{0: types.Int(3), 1: types.Uint(1), 2: types.Uint(0)},
+	}
+
+	expected := `{"rows": [{"id":1,"size":"medium","tags":"a,c"},{"id":2,"size":"large","tags":"b"},{"id":3,"size":"small","tags":""}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows))
+
+	opts := []WriterOption{WithSetsAsArrays(true), WithEnumsAsIndexes(true)}
+	expected = `{"rows": [{"id":1,"size":2,"tags":["a","c"]},{"id":2,"size":3,"tags":["b"]},{"id":3,"size":1,"tags":[]}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, opts...))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, opts...))
+
+	expected = `{"rows": [{"id":1,"size":"medium","tags":["a","c"]},{"id":2,"size":"large","tags":["b"]},{"id":3,"size":"small","tags":[]}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithSetsAsArrays(true)))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithSetsAsArrays(true)))
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	binType, err := typeinfo.FromSqlType(sql.MustCreateBinary(sqltypes.VarBinary, 16))
+	require.NoError(t, err)
+	blobType, err := typeinfo.FromSqlType(sql.Blob)
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "bin", Tag: 1, Kind: types.InlineBlobKind, TypeInfo: binType},
+		schema.Column{Name: "data", Tag: 2, Kind: types.BlobKind, TypeInfo: blobType},
+	)
+	raw := []byte{0xff, 0xfe, 0x00, 'a', 0xc3}
+
+	out := writeSqlRows(t, sch, []sql.Row{{int64(1), raw, string(raw)}}, WithBinaryEncoding(BinaryBase64))
+	var doc struct {
+		Rows []struct {
+			Bin  string `json:"bin"`
+			Data string `json:"data"`
+		} `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &doc))
+	require.Len(t, doc.Rows, 1)
+	for _, enc := range []string{doc.Rows[0].Bin, doc.Rows[0].Data} {
+		decoded, err := base64.StdEncoding.DecodeString(enc)
+		require.NoError(t, err)
+		assert.Equal(t, raw, decoded)
+	}
+
+	nomsSch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "bin", Tag: 1, Kind: types.InlineBlobKind, TypeInfo: binType},
+	)
+	assert.Equal(t, `{"rows": [{"id":1,"bin":"//4AYcM="}]}`,
+		writeNomsRows(t, nomsSch, []row.TaggedValues{{0: types.Int(1), 1: types.InlineBlob(raw)}}, WithBinaryEncoding(BinaryBase64)))
+	assert.Equal(t, `{"rows": [{"id":1,"bin":"text"}]}`,
+		writeNomsRows(t, nomsSch, []row.TaggedValues{{0: types.Int(1), 1: types.InlineBlob("text")}}))
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBinaryEncoding("hex"))
+	assert.Error(t, err)
+}
+
+func TestSpatialEncoding(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "pt", Tag: 1, Kind: types.PointKind, TypeInfo: typeinfo.PointType},
+		schema.Column{Name: "line", Tag: 2, Kind: types.LineStringKind, TypeInfo: typeinfo.LineStringType},
+		schema.Column{Name: "poly", Tag: 3, Kind: types.PolygonKind, TypeInfo: typeinfo.PolygonType},
+		schema.Column{Name: "geom", Tag: 4, Kind: types.GeometryKind, TypeInfo: typeinfo.GeometryType},
+	)
+	line := sql.LineString{Points: []sql.Point{{X: 0, Y: 0}, {X: 1, Y: 1.5}}}
+	ring := sql.LineString{Points: []sql.Point{{X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 1}, {X: 0, Y: 0}}}
+	rows := []sql.Row{
+		{int64(1), sql.Point{X: 1, Y: 2}, line, sql.Polygon{Lines: []sql.LineString{ring}}, sql.Point{SRID: sql.GeoSpatialSRID, X: 3, Y: 4}},
+		{int64(2), nil, nil, nil, nil},
+	}
+
+	assert.Equal(t, `{"rows": [`+
+		`{"id":1,"pt":{"type":"Point","coordinates":[1,2]},"line":{"type":"LineString","coordinates":[[0,0],[1,1.5]]},`+
+		`"poly":{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[0,0]]]},"geom":{"type":"Point","coordinates":[3,4]}},`+
+		`{"id":2}]}`, writeSqlRows(t, sch, rows))
+
+	// the axes of geographic points are swapped in WKT, as ST_AsWKT does
+	assert.Equal(t, `{"rows": [`+
+		`{"id":1,"pt":"POINT(1 2)","line":"LINESTRING(0 0,1 1.5)","poly":"POLYGON((0 0,0 1,1 1,0 0))","geom":"POINT(4 3)"},`+
+		`{"id":2}]}`, writeSqlRows(t, sch, rows, WithSpatialEncoding(SpatialWKT)))
+
+	nomsSch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "pt", Tag: 1, Kind: types.PointKind, TypeInfo: typeinfo.PointType},
+		schema.Column{Name: "geom", Tag: 2, Kind: types.GeometryKind, TypeInfo: typeinfo.GeometryType},
+	)
+	nomsRows := []row.TaggedValues{{
+		0: types.Int(1),
+		1: types.Point{X: 1, Y: 2},
+		2: types.Geometry{Inner: types.LineString{Points: []types.Point{{X: 0, Y: 0}, {X: 1, Y: 1.5}}}},
+	}}
+	assert.Equal(t, `{"rows": [{"id":1,"pt":{"type":"Point","coordinates":[1,2]},"geom":{"type":"LineString","coordinates":[[0,0],[1,1.5]]}}]}`,
+		writeNomsRows(t, nomsSch, nomsRows))
+	assert.Equal(t, `{"rows": [{"id":1,"pt":"POINT(1 2)","geom":"LINESTRING(0 0,1 1.5)"}]}`,
+		writeNomsRows(t, nomsSch, nomsRows, WithSpatialEncoding(SpatialWKT)))
+
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithSpatialEncoding("wkb"))
+	assert.Error(t, err)
+}
+
+func TestIndent(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "doc", Tag: 1, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+	rows := []sql.Row{
+		{int64(1), sql.MustJSON(`{"a": [1, 2]}`)},
+		{int64(2), nil},
+	}
+
+	expected := `{"rows": [
+  {
+    "id": 1,
+    "doc": {
+      "a": [
+        1,
+        2
+      ]
+    }
+  },
+  {
+    "id": 2
+  }
+]}`
+	out := writeSqlRows(t, sch, rows, WithIndent("  "))
+	assert.Equal(t, expected, out)
+	assert.True(t, json.Valid([]byte(out)))
+
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, "[", "]", ", ", WithIndent("\t"))
+	require.NoError(t, err)
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, "[\n\t{\n\t\t\"id\": 1,\n\t\t\"doc\": {\n\t\t\t\"a\": [\n\t\t\t\t1,\n\t\t\t\t2\n\t\t\t]\n\t\t}\n\t},\n\t{\n\t\t\"id\": 2\n\t}\n]", buf.String())
+
+	assert.Equal(t, `{"rows": []}`, writeSqlRows(t, sch, nil, WithIndent("  ")))
+
+	chained := writeSqlRows(t, sch, rows, WithIndent("  "), WithHashChain(true))
+	assert.NoError(t, VerifyHashChain(strings.NewReader(chained), DefaultHashChainGenesis))
+
+	_, err = NewNDJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithIndent("  "))
+	assert.Error(t, err)
+	_, err = NewCBORWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithIndent("  "))
+	assert.Error(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithIndent("--"))
+	assert.Error(t, err)
+}
+
+func TestColumnProjection(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "age", Tag: 2, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "city", Tag: 3, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	sqlRows := []sql.Row{{int64(1), "ann", int64(30), "oslo"}, {int64(2), "bob", nil, "rome"}}
+	nomsRows := []row.TaggedValues{
+		{0: types.Int(1), 1: types.String("ann"), 2: types.Int(30), 3: types.String("oslo")},
+		{0: types.Int(2), 1: types.String("bob"), 3: types.String("rome")},
+	}
+
+	expected := `{"rows": [{"age":30,"id":1},{"id":2}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, WithColumnProjection([]string{"age", "id"})))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, WithColumnProjection([]string{"age", "id"})))
+	assert.Equal(t, `{"rows": [{"city":"oslo","age":30},{"city":"rome","age":null}]}`,
+		writeSqlRows(t, sch, sqlRows, WithColumnProjection([]string{"city", "age"}), WithIncludeNulls(true)))
+
+	newWriter := func(opts ...WriterOption) error {
+		_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, opts...)
+		return err
+	}
+	assert.Error(t, newWriter(WithColumnProjection([]string{"id", "zip"})))
+	assert.Error(t, newWriter(WithColumnProjection([]string{"id", "id"})))
+	assert.Error(t, newWriter(WithColumnProjection(nil)))
+	assert.Error(t, newWriter(WithColumnProjection([]string{"id"}), WithColumnProfile([]string{"city"})))
+	assert.Error(t, newWriter(WithColumnProjection([]string{"name"}), WithHashBucketing(2)))
+	assert.NoError(t, newWriter(WithColumnProjection([]string{"city", "id"}), WithHashBucketing(2)))
+}
+
+func TestDatetimeLayout(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "dt", Tag: 1, Kind: types.TimestampKind, TypeInfo: typeinfo.DatetimeType},
+		schema.Column{Name: "d", Tag: 2, Kind: types.TimestampKind, TypeInfo: typeinfo.DateType},
+		schema.Column{Name: "t", Tag: 3, Kind: types.IntKind, TypeInfo: typeinfo.TimeType},
+	)
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	dt := time.Date(2024, 1, 15, 3, 4, 5, 0, time.UTC)
+	d := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	zero := time.Unix(-62167219200, 0).UTC()
+	sqlRows := []sql.Row{
+		{int64(1), dt, d, sql.Timespan(45296000000)},
+		{int64(2), zero, zero, sql.Timespan(-3600000000)},
+	}
+	nomsRows := []row.TaggedValues{
+		{0: types.Int(1), 1: types.Timestamp(dt), 2: types.Timestamp(d), 3: types.Int(45296000000)},
+		{0: types.Int(2), 1: types.Timestamp(zero), 2: types.Timestamp(zero), 3: types.Int(-3600000000)},
+	}
+
+	opts := []WriterOption{WithDatetimeLayout(time.RFC3339, ny)}
+	expected := `{"rows": [` +
+		`{"id":1,"dt":"2024-01-14T22:04:05-05:00","d":"2024-01-15T00:00:00Z","t":"0000-01-01T12:34:56Z"},` +
+		`{"id":2,"dt":"0000-00-00 00:00:00","d":"0000-00-00","t":"-01:00:00"}]}`
+	assert.Equal(t, expected, writeSqlRows(t, sch, sqlRows, opts...))
+	assert.Equal(t, expected, writeNomsRows(t, sch, nomsRows, opts...))
+
+	expected = `{"rows": [{"id":1,"dt":"2024-01-15T03:04:05Z","d":"2024-01-15T00:00:00Z","t":"0000-01-01T12:34:56Z"},`
+	assert.True(t, strings.HasPrefix(writeSqlRows(t, sch, sqlRows, WithDatetimeLayout(time.RFC3339, nil)), expected))
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithDatetimeLayout("", nil))
+	assert.Error(t, err)
+}
+
+func TestFooterRowCountAndMetadata(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}}
+	metadata := func() map[string]interface{} {
+		return map[string]interface{}{"table": "t1", "exported_at": "2024-01-15T00:00:00Z"}
+	}
+
+	assert.Equal(t, `{"rows": [{"id":1},{"id":2}]}`, writeSqlRows(t, sch, rows))
+	assert.Equal(t, `{"rows": [{"id":1},{"id":2}],"row_count":2}`, writeSqlRows(t, sch, rows, WithFooterRowCount(true)))
+	assert.Equal(t, `{"rows": [],"row_count":0}`, writeSqlRows(t, sch, nil, WithFooterRowCount(true)))
+	assert.Equal(t, `{"rows": [{"id":1},{"id":2}],"row_count":2,"exported_at":"2024-01-15T00:00:00Z","table":"t1"}`,
+		writeSqlRows(t, sch, rows, WithFooterRowCount(true), WithFooterMetadata(metadata)))
+
+	out := writeSqlRows(t, sch, rows, WithFooterMetadata(metadata), WithDocumentChecksum(ChecksumSHA256))
+	assert.True(t, strings.HasPrefix(out, `{"rows": [{"id":1},{"id":2}],"exported_at":"2024-01-15T00:00:00Z","table":"t1","checksum":"sha256:`))
+	assert.NoError(t, VerifyDocumentChecksum(strings.NewReader(out)))
+
+	for _, key := range []string{"rows", "row_count", "checksum"} {
+		wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithFooterRowCount(true), WithFooterMetadata(func() map[string]interface{} {
+			return map[string]interface{}{key: "x"}
+		}))
+		require.NoError(t, err)
+		assert.Error(t, wr.Close(context.Background()), key)
+	}
+
+	_, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&bytes.Buffer{}), sch, "[", "]", ",", WithFooterRowCount(true))
+	assert.Error(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithFooterRowCount(true), WithLeadingRowCount(true))
+	assert.Error(t, err)
+}
+
+// chunkRecorder records the chunks written to it, failing every write once |failAfter| chunks have been written
+type chunkRecorder struct {
+	chunks    []string
+	failAfter int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	if c.failAfter > 0 && len(c.chunks) >= c.failAfter {
+		return 0, errors.New("destination unavailable")
+	}
+	c.chunks = append(c.chunks, string(p))
+	return len(p), nil
+}
+
+func TestFlushEvery(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	rows := []sql.Row{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}, {int64(5)}}
+
+	rec := &chunkRecorder{}
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(rec), sch, WithFlushEvery(2))
+	require.NoError(t, err)
+	for _, r := range rows {
+		require.NoError(t, wr.WriteSqlRow(context.Background(), r))
+	}
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, []string{`{"rows": [{"id":1},{"id":2}`, `,{"id":3},{"id":4}`, `,{"id":5}]}`}, rec.chunks)
+
+	rec = &chunkRecorder{}
+	wr, err = NewNDJSONWriter(iohelp.NopWrCloser(rec), sch, WithFlushEvery(2), WithBatchMarkers(true))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows[:3]))
+	assert.Equal(t, []string{"{\"id\":1}\n{\"id\":2}\n{\"_batch_end\":true}"}, rec.chunks)
+
+	rec = &chunkRecorder{failAfter: 1}
+	wr, err = NewJSONWriter(iohelp.NopWrCloser(rec), sch, WithFlushEvery(1))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), rows[0]))
+	assert.EqualError(t, wr.WriteSqlRow(context.Background(), rows[1]), "destination unavailable")
+	assert.Equal(t, 2, wr.rowsWritten)
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(rec), sch, WithFlushEvery(-1))
+	assert.Error(t, err)
+}
+
+// closeOrderRecorder is a destination that rejects writes once it's closed
+type closeOrderRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeOrderRecorder) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errors.New("write after close")
+	}
+	return c.Buffer.Write(p)
+}
+
+func (c *closeOrderRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestGzipWriter(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	var rows []sql.Row
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, sql.Row{int64(i), fmt.Sprintf("name %d", i)})
+	}
+	opts := []WriterOption{WithFooterRowCount(true), WithDocumentChecksum(ChecksumSHA256)}
+	expected := writeSqlRows(t, sch, rows, opts...)
+
+	dest := &closeOrderRecorder{}
+	wr, err := NewGzipJSONWriter(dest, sch, gzip.BestSpeed, opts...)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, wr.Close(context.Background()))
+	assert.True(t, dest.closed)
+	assert.Less(t, dest.Len(), len(expected))
+
+	gz, err := gzip.NewReader(&dest.Buffer)
+	require.NoError(t, err)
+	out, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(out))
+	assert.NoError(t, VerifyDocumentChecksum(bytes.NewReader(out)))
+
+	// a flush makes the rows written so far readable from the compressed stream
+	dest = &closeOrderRecorder{}
+	wr, err = NewGzipJSONWriter(dest, sch, gzip.DefaultCompression)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), rows[0]))
+	require.NoError(t, wr.Flush())
+	gz, err = gzip.NewReader(bytes.NewReader(dest.Bytes()))
+	require.NoError(t, err)
+	partial, _ := io.ReadAll(gz)
+	assert.Equal(t, `{"rows": [{"id":0,"name":"name 0"}`, string(partial))
+
+	_, err = NewGzipJSONWriter(&closeOrderRecorder{}, sch, 10)
+	assert.Error(t, err)
+	_, err = NewGzipJSONWriter(&closeOrderRecorder{}, sch, gzip.DefaultCompression, WithLeadingRowCount(true))
+	assert.Error(t, err)
+}
+
+func TestNestedColumnPaths(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "address.city", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "name", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "address.geo.lat", Tag: 3, Kind: types.FloatKind, TypeInfo: typeinfo.Float64Type},
+		schema.Column{Name: "address.zip", Tag: 4, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{
+		{int64(1), "Madrid", "ana", 40.4, "28001"},
+		{int64(2), nil, "bo", nil, "08001"},
+		{int64(3), nil, nil, nil, nil},
+	}
+
+	assert.Equal(t, `{"rows": [`+
+		`{"id":1,"address":{"city":"Madrid","geo":{"lat":40.4},"zip":"28001"},"name":"ana"},`+
+		`{"id":2,"address":{"zip":"08001"},"name":"bo"},`+
+		`{"id":3}]}`, writeSqlRows(t, sch, rows, WithNestedColumnPaths(".")))
+	assert.Equal(t, `{"rows": [{"id":1,"address.city":"Madrid","name":"ana","address.geo.lat":40.4,"address.zip":"28001"}]}`,
+		writeSqlRows(t, sch, rows[:1]))
+
+	nomsRows := []row.TaggedValues{{0: types.Int(1), 1: types.String("Madrid"), 4: types.String("28001")}}
+	assert.Equal(t, `{"rows": [{"id":1,"address":{"city":"Madrid","zip":"28001"}}]}`,
+		writeNomsRows(t, sch, nomsRows, WithNestedColumnPaths(".")))
+
+	conflicting := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "address", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "address__city", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	_, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), conflicting, WithNestedColumnPaths("__"))
+	assert.EqualError(t, err, "field 'address__city' conflicts with the value of field 'address'")
+
+	// a field added by the writer conflicts with the columns nested under its name
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithNestedColumnPaths("."),
+		WithCoalesce("address", []string{"address.city", "address.zip"}, false))
+	assert.EqualError(t, err, "field 'address' conflicts with the nested fields under it")
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "ID", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "Status", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "Addr.City", Tag: 2, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "Addr.Zip", Tag: 3, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "Doc", Tag: 4, Kind: types.JSONKind, TypeInfo: typeinfo.JSONType},
+	)
+	var rows []sql.Row
+	for i := 0; i < 50; i++ {
+		doc := make(map[string]interface{})
+		for k := 0; k < 20; k++ {
+			doc[fmt.Sprintf("Key%d", k)] = map[string]interface{}{"A": i, "B": k, "C": []interface{}{map[string]interface{}{"X": i, "Y": k}}}
+		}
+		rows = append(rows, sql.Row{int64(i), fmt.Sprintf("status %d", i%7), fmt.Sprintf("city %d", i), nil, sql.JSONDocument{Val: doc}})
+	}
+	metadata := make(map[string]interface{})
+	for k := 0; k < 20; k++ {
+		metadata[fmt.Sprintf("meta%d", k)] = k
+	}
+
+	optSets := map[string][]WriterOption{
+		"default": nil,
+		"all": {
+			WithKeyNameFunc(strings.ToLower),
+			WithNestedKeyTransform(true),
+			WithNestedColumnPaths("."),
+			WithColumnProfile([]string{"Status"}),
+			WithFooterRowCount(true),
+			WithFooterMetadata(func() map[string]interface{} { return metadata }),
+			WithDocumentChecksum(ChecksumSHA256),
+		},
+		"normalized": {WithNormalizeJSONColumns(true), WithIncludeNulls(true), WithIndent("  ")},
+	}
+	for name, opts := range optSets {
+		t.Run(name, func(t *testing.T) {
+			first := writeSqlRows(t, sch, rows, opts...)
+			for i := 0; i < 10; i++ {
+				require.Equal(t, first, writeSqlRows(t, sch, rows, opts...))
+			}
+		})
+	}
+
+	writeCBOR := func() []byte {
+		var buf bytes.Buffer
+		wr, err := NewCBORWriter(iohelp.NopWrCloser(&buf), sch)
+		require.NoError(t, err)
+		require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+		require.NoError(t, wr.Close(context.Background()))
+		return buf.Bytes()
+	}
+	first := writeCBOR()
+	for i := 0; i < 10; i++ {
+		require.Equal(t, first, writeCBOR())
+	}
+}
+
+func TestReset(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "status", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	otherSch := mustSchema(t,
+		schema.Column{Name: "name", Tag: 0, Kind: types.StringKind, IsPartOfPK: true, TypeInfo: typeinfo.StringDefaultType},
+		schema.Column{Name: "status", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{{int64(1), "open"}, {int64(2), "closed"}}
+	otherRows := []sql.Row{{"a", "open"}}
+	opts := []WriterOption{
+		WithFooterRowCount(true),
+		WithColumnProfile([]string{"status"}),
+		WithDocumentChecksum(ChecksumSHA256),
+		WithBufferSize(64),
+	}
+
+	var first, second bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&first), sch, opts...)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	assert.Error(t, wr.Reset(iohelp.NopWrCloser(&second), otherSch))
+	require.NoError(t, wr.Close(context.Background()))
+
+	bWr := wr.bWr
+	require.NoError(t, wr.Reset(iohelp.NopWrCloser(&second), otherSch))
+	assert.Same(t, bWr, wr.bWr)
+	assert.Equal(t, otherSch, wr.GetSchema())
+	require.NoError(t, wr.WriteSqlRows(context.Background(), otherRows))
+	require.NoError(t, wr.Close(context.Background()))
+
+	// each document is the same as that of a new writer
+	assert.Equal(t, writeSqlRows(t, sch, rows, opts...), first.String())
+	assert.Equal(t, writeSqlRows(t, otherSch, otherRows, opts...), second.String())
+	for _, doc := range []bytes.Buffer{first, second} {
+		assert.True(t, json.Valid(doc.Bytes()))
+		assert.NoError(t, VerifyDocumentChecksum(bytes.NewReader(doc.Bytes())))
+	}
+
+	// a hash chain starts over from its genesis value, and a compressed document gets its own gzip stream
+	var chained bytes.Buffer
+	wr, err = NewGzipJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, gzip.DefaultCompression, WithHashChain(true))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, wr.Close(context.Background()))
+	require.NoError(t, wr.Reset(iohelp.NopWrCloser(&chained), sch))
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, wr.Close(context.Background()))
+	gz, err := gzip.NewReader(&chained)
+	require.NoError(t, err)
+	out, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, writeSqlRows(t, sch, rows, WithHashChain(true)), string(out))
+	assert.NoError(t, VerifyHashChain(bytes.NewReader(out), DefaultHashChainGenesis))
+
+	projected, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithColumnProjection([]string{"id"}))
+	require.NoError(t, err)
+	require.NoError(t, projected.Close(context.Background()))
+	assert.Error(t, projected.Reset(iohelp.NopWrCloser(&bytes.Buffer{}), otherSch))
+
+	// options that depend on the schema's columns are checked against the new one
+	keylessSch := mustSchema(t,
+		schema.Column{Name: "status", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	tests := []struct {
+		name string
+		opt  WriterOption
+		sch  schema.Schema
+	}{
+		{"coalesce source", WithCoalesce("label", []string{"id"}, false), otherSch},
+		{"profiled column", WithColumnProfile([]string{"id"}), otherSch},
+		{"hash bucketing", WithHashBucketing(4), keylessSch},
+		{"surrogate id", WithSurrogateID("_id"), sch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			initSch := sch
+			if test.sch == sch {
+				initSch = keylessSch
+			}
+			wr, err := NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), initSch, test.opt)
+			require.NoError(t, err)
+			require.NoError(t, wr.Close(context.Background()))
+			assert.Error(t, wr.Reset(iohelp.NopWrCloser(&bytes.Buffer{}), test.sch))
+			assert.Equal(t, initSch, wr.GetSchema())
+		})
+	}
+}
+
+func TestTableWrapping(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{{int64(1), "a"}, {int64(2), nil}}
+
+	out := writeSqlRows(t, sch, rows, WithTableWrapping("users"))
+	assert.Equal(t, `{"rows": [{"table":"users","row":{"id":1,"name":"a"}},{"table":"users","row":{"id":2}}]}`, out)
+	assert.True(t, json.Valid([]byte(out)))
+	assert.Equal(t, `{"rows": [{"table":"users","row":{"id":1,"name":"a"}}]}`,
+		writeNomsRows(t, sch, []row.TaggedValues{{0: types.Int(1), 1: types.String("a")}}, WithTableWrapping("users")))
+	assert.Equal(t, `{"rows": [{"id":1,"name":"a"},{"id":2}]}`, writeSqlRows(t, sch, rows))
+
+	var buf bytes.Buffer
+	wr, err := NewNDJSONWriter(iohelp.NopWrCloser(&buf), sch, WithTableWrapping("users"))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, "{\"table\":\"users\",\"row\":{\"id\":1,\"name\":\"a\"}}\n{\"table\":\"users\",\"row\":{\"id\":2}}\n", buf.String())
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithTableWrapping(""))
+	assert.Error(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithTableWrapping("users"), WithHashChain(true))
+	assert.Error(t, err)
+}
+
+func TestEnvelopeValidation(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+	)
+	newWriter := func(header, footer, separator string, opts ...WriterOption) error {
+		_, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&bytes.Buffer{}), sch, header, footer, separator, opts...)
+		return err
+	}
+
+	assert.NoError(t, newWriter("[", "]", ","))
+	assert.NoError(t, newWriter(`{"data": [`, `], "more": false}`, ", "))
+	assert.NoError(t, newWriter("", "", "\n"))
+	assert.EqualError(t, newWriter(`{"rows": [`, "}]", ","),
+		`json header "{\"rows\": [", footer "}]" and separator "," don't make a valid json document, e.g. {"rows": [}]`)
+	assert.Error(t, newWriter("[", "]", ";"))
+	assert.Error(t, newWriter("", "", ""))
+	assert.Error(t, newWriter("{", "}", ","))
+
+	// fragments are written as given when validation is disabled
+	var buf bytes.Buffer
+	wr, err := NewJSONWriterWithHeader(iohelp.NopWrCloser(&buf), sch, `"rows": [`, "]", ",", WithEnvelopeValidation(false))
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRows(context.Background(), []sql.Row{{int64(1)}, {int64(2)}}))
+	require.NoError(t, wr.Close(context.Background()))
+	assert.Equal(t, `"rows": [{"id":1},{"id":2}]`, buf.String())
+}
+
+func TestBytesWritten(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "name", Tag: 1, Kind: types.StringKind, TypeInfo: typeinfo.StringDefaultType},
+	)
+	rows := []sql.Row{{int64(1), "a"}, {int64(2), nil}, {int64(3), "c"}}
+
+	tests := []struct {
+		name string
+		new  func(wr io.WriteCloser) (*RowWriter, error)
+	}{
+		{"default", func(wr io.WriteCloser) (*RowWriter, error) { return NewJSONWriter(wr, sch) }},
+		{"footer fields", func(wr io.WriteCloser) (*RowWriter, error) {
+			return NewJSONWriter(wr, sch, WithFooterRowCount(true), WithDocumentChecksum(ChecksumSHA256))
+		}},
+		{"indented", func(wr io.WriteCloser) (*RowWriter, error) { return NewJSONWriter(wr, sch, WithIndent("  ")) }},
+		{"bucketed", func(wr io.WriteCloser) (*RowWriter, error) { return NewJSONWriter(wr, sch, WithHashBucketing(2)) }},
+		{"ndjson", func(wr io.WriteCloser) (*RowWriter, error) { return NewNDJSONWriter(wr, sch) }},
+		{"cbor", func(wr io.WriteCloser) (*RowWriter, error) { return NewCBORWriter(wr, sch) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			wr, err := test.new(iohelp.NopWrCloser(&buf))
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), wr.BytesWritten())
+			require.NoError(t, wr.WriteSqlRows(context.Background(), rows))
+			require.NoError(t, wr.Close(context.Background()))
+			assert.Equal(t, int64(buf.Len()), wr.BytesWritten())
+		})
+	}
+
+	// buffered bytes are counted before they're flushed, and a reset writer counts from zero
+	var buf bytes.Buffer
+	wr, err := NewJSONWriter(iohelp.NopWrCloser(&buf), sch)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteSqlRow(context.Background(), rows[0]))
+	assert.Equal(t, int64(len(`{"rows": [{"id":1,"name":"a"}`)), wr.BytesWritten())
+	assert.Equal(t, 0, buf.Len())
+	require.NoError(t, wr.Close(context.Background()))
+	require.NoError(t, wr.Reset(iohelp.NopWrCloser(&bytes.Buffer{}), sch))
+	assert.Equal(t, int64(0), wr.BytesWritten())
+
+	// the bytes of a compressed document are counted before compression
+	gzipped, err := NewGzipJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, gzip.BestSpeed)
+	require.NoError(t, err)
+	require.NoError(t, gzipped.WriteSqlRows(context.Background(), rows))
+	require.NoError(t, gzipped.Close(context.Background()))
+	assert.Equal(t, int64(len(writeSqlRows(t, sch, rows))), gzipped.BytesWritten())
+}
+
+func TestBitEncoding(t *testing.T) {
+	flagType, err := typeinfo.FromSqlType(sql.MustCreateBitType(1))
+	require.NoError(t, err)
+	wideType, err := typeinfo.FromSqlType(sql.MustCreateBitType(64))
+	require.NoError(t, err)
+	sch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "flag", Tag: 1, Kind: types.UintKind, TypeInfo: flagType},
+		schema.Column{Name: "mask", Tag: 2, Kind: types.UintKind, TypeInfo: wideType},
+	)
+	flagSch := mustSchema(t,
+		schema.Column{Name: "id", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "flag", Tag: 1, Kind: types.UintKind, TypeInfo: flagType},
+	)
+	rows := []sql.Row{{int64(1), uint64(1), uint64(math.MaxUint64)}, {int64(2), uint64(0), uint64(255)}}
+
+	assert.Equal(t, `{"rows": [{"id":1,"flag":1,"mask":18446744073709551615},{"id":2,"flag":0,"mask":255}]}`,
+		writeSqlRows(t, sch, rows))
+	assert.Equal(t, `{"rows": [{"id":1,"flag":1,"mask":18446744073709551615},{"id":2,"flag":0,"mask":255}]}`,
+		writeSqlRows(t, sch, rows, WithBitEncoding(BitInteger)))
+	assert.Equal(t, `{"rows": [{"id":1,"flag":"0x1","mask":"0xFFFFFFFFFFFFFFFF"},{"id":2,"flag":"0x0","mask":"0xFF"}]}`,
+		writeSqlRows(t, sch, rows, WithBitEncoding(BitHexString)))
+	assert.Equal(t, `{"rows": [{"id":1,"flag":true},{"id":2,"flag":false}]}`,
+		writeSqlRows(t, flagSch, []sql.Row{{int64(1), uint64(1)}, {int64(2), uint64(0)}}, WithBitEncoding(BitBoolean)))
+	// SQL replay always writes integers
+	assert.Equal(t, `{"rows": [{"id":1,"flag":1}]}`,
+		writeSqlRows(t, flagSch, []sql.Row{{int64(1), uint64(1)}}, WithBitEncoding(BitBoolean), WithSQLReplay(true)))
+
+	nomsRows := []row.TaggedValues{{0: types.Int(1), 1: types.Uint(1), 2: types.Uint(0xAB)}}
+	assert.Equal(t, `{"rows": [{"id":1,"flag":"0x1","mask":"0xAB"}]}`,
+		writeNomsRows(t, sch, nomsRows, WithBitEncoding(BitHexString)))
+	assert.Equal(t, `{"rows": [{"id":1,"flag":true}]}`,
+		writeNomsRows(t, flagSch, []row.TaggedValues{{0: types.Int(1), 1: types.Uint(1)}}, WithBitEncoding(BitBoolean)))
+
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBitEncoding(BitBoolean))
+	assert.EqualError(t, err, "column 'mask' of type BIT(64) can't be written as a boolean")
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBitEncoding(BitBoolean), WithColumnProjection([]string{"id", "flag"}))
+	assert.NoError(t, err)
+	_, err = NewJSONWriter(iohelp.NopWrCloser(&bytes.Buffer{}), sch, WithBitEncoding("octal"))
+	assert.Error(t, err)
+}
+
+func TestJsonDiffWriterSortsFields(t *testing.T) {
+	sch := mustSchema(t,
+		schema.Column{Name: "pk", Tag: 0, Kind: types.IntKind, IsPartOfPK: true, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "c2", Tag: 1, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+		schema.Column{Name: "c1", Tag: 2, Kind: types.IntKind, TypeInfo: typeinfo.Int64Type},
+	)
+
+	var buf bytes.Buffer
+	wr, err := NewJsonDiffWriter(iohelp.NopWrCloser(&buf), sch)
+	require.NoError(t, err)
+	require.NoError(t, wr.WriteRow(context.Background(), sql.Row{int64(0), int64(2), int64(1)}, diff.Added,
+		[]diff.ChangeType{diff.Added, diff.Added, diff.Added}))
+	assert.Equal(t, `{"from_row":{},"to_row":{"c1":1,"c2":2,"pk":0}}`, buf.String())
+}
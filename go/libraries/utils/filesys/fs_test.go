@@ -86,6 +86,17 @@ func TestFilesystems(t *testing.T) {
 			require.NoError(t, err)
 			require.Equal(t, dataRead, data)
 
+			// Test appending to the file
+			wr, err := fs.OpenForWriteAppend(fp, os.ModePerm)
+			require.NoError(t, err)
+			_, err = wr.Write([]byte(testString))
+			require.NoError(t, err)
+			require.NoError(t, wr.Close())
+			dataRead, err = fs.ReadFile(fp)
+			require.NoError(t, err)
+			require.Equal(t, append(data, testString...), dataRead)
+			data = dataRead
+
 			// Test moving the file
 			err = fs.MoveFile(fp, movedFilePath)
 			require.NoError(t, err)
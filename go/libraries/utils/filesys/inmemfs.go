@@ -353,7 +353,12 @@ func (fs *InMemFS) OpenForWriteAppend(fp string, perm os.FileMode) (io.WriteClos
 		return nil, err
 	}
 
-	return &inMemFSWriteCloser{fp, parentDir, fs, bytes.NewBuffer(make([]byte, 0, 512)), fs.rwLock}, nil
+	buf := bytes.NewBuffer(make([]byte, 0, 512))
+	if f, ok := fs.objs[fp].(*memFile); ok {
+		buf.Write(f.data)
+	}
+
+	return &inMemFSWriteCloser{fp, parentDir, fs, buf, fs.rwLock}, nil
 }
 
 // WriteFile writes the entire data buffer to a given file.  The file will be created if it does not exist,